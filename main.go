@@ -1,27 +1,62 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/goraft/raft"
 	"github.com/mauidude/deduper/minhash"
 	"github.com/mauidude/deduper/server"
+	"github.com/mauidude/deduper/server/backup"
 	"github.com/mauidude/deduper/server/command"
+	"github.com/mauidude/deduper/server/middleware"
+	"github.com/mauidude/deduper/simhash"
+	"github.com/mauidude/deduper/text"
 )
 
 type config struct {
-	path     string
-	host     string
-	port     int
-	leader   string
-	debug    bool
-	bands    int
-	rows     int
-	shingles int
+	path          string
+	host          string
+	port          int
+	leader        string
+	debug         bool
+	bands         int
+	rows          int
+	shingles      int
+	normalizers   string
+	tokenizer     string
+	stopWords     string
+	jsonField     string
+	short         string
+	hashFamily    string
+	seed          int64
+	algorithm     string
+	distance      int
+	passages      string
+	passageSize   int
+	storeShingles bool
+	maxBodySize   int64
+	bBits         int
+	storage       string
+	standalone    bool
+	checkpoint    time.Duration
+	ttl           time.Duration
+	expire        time.Duration
+	maxDocuments  int
+	eviction      string
+	forward       time.Duration
+	forwarding    string
+	backupDir     string
+	adminToken    string
 }
 
 var cfg *config
@@ -33,9 +68,34 @@ func init() {
 	flag.IntVar(&cfg.port, "port", 8080, "The HTTP port for this server to run on")
 	flag.StringVar(&cfg.leader, "leader", "", "The HTTP host and port of the leader")
 	flag.BoolVar(&cfg.debug, "debug", false, "Enable debug logging")
+	flag.DurationVar(&cfg.forward, "forward-timeout", middleware.DefaultTimeout, "How long a write forwarded to the leader may take")
+	flag.StringVar(&cfg.forwarding, "forwarding", "proxy", "How followers forward writes to the leader, proxy or redirect")
+	flag.StringVar(&cfg.backupDir, "backup-dir", "", "The directory backups are written to, only streamed if empty")
+	flag.StringVar(&cfg.adminToken, "admin-token", "", "The shared secret of the /admin routes, disabled if empty")
+	flag.BoolVar(&cfg.standalone, "standalone", false, "Run as a single node without Raft")
+	flag.DurationVar(&cfg.checkpoint, "checkpoint-interval", time.Minute, "How often a standalone node checkpoints its index, never if zero")
+	flag.DurationVar(&cfg.expire, "expire-interval", time.Minute, "How often the leader removes expired documents, never if zero")
 	flag.IntVar(&cfg.bands, "bands", 100, "Number of bands")
 	flag.IntVar(&cfg.rows, "hashes", 2, "Number of hashes to use")
 	flag.IntVar(&cfg.shingles, "shingles", 2, "Number of shingles")
+	flag.StringVar(&cfg.normalizers, "normalizers", strings.Join(text.DefaultNormalizers, ","), "Comma separated list of text normalizers")
+	flag.StringVar(&cfg.tokenizer, "tokenizer", text.DefaultTokenizer, "The tokenizer used to split text into words")
+	flag.StringVar(&cfg.stopWords, "stopwords", "", "The language of the stop words to remove from text")
+	flag.StringVar(&cfg.hashFamily, "hash", minhash.DefaultHashFamily, "The hash family used to compute signatures")
+	flag.Int64Var(&cfg.seed, "seed", 0, "The seed of the hash functions, random if zero")
+	flag.StringVar(&cfg.short, "short", minhash.ShortShingle, "How documents with fewer words than the shingle size are handled, shingle or reject")
+	flag.StringVar(&cfg.algorithm, "algorithm", minhash.AlgorithmMinHash, "The algorithm used to find similar documents, minhash or simhash")
+	flag.IntVar(&cfg.distance, "distance", minhash.DefaultDistance, "The largest Hamming distance between similar documents when using simhash")
+	flag.StringVar(&cfg.passages, "passages", "", "How documents are split into passages, paragraph or tokens, none if empty")
+	flag.IntVar(&cfg.passageSize, "passage-size", minhash.DefaultPassageSize, "The number of tokens in each passage when splitting by tokens")
+	flag.IntVar(&cfg.bBits, "b-bits", 32, "The number of low bits of each hash to store, 1, 2, 4, 8, 16 or 32")
+	flag.StringVar(&cfg.storage, "storage", minhash.StorageMemory, "Where signatures are stored, memory or disk")
+	flag.DurationVar(&cfg.ttl, "ttl", 0, "How long documents are kept before they expire, forever if zero")
+	flag.IntVar(&cfg.maxDocuments, "max-documents", 0, "The most documents kept before documents are evicted, unlimited if zero")
+	flag.StringVar(&cfg.eviction, "eviction", minhash.EvictFIFO, "How documents are chosen to be evicted, fifo or lru")
+	flag.BoolVar(&cfg.storeShingles, "store-shingles", false, "Store the shingles of documents so explanations include exact similarities")
+	flag.Int64Var(&cfg.maxBodySize, "max-body-size", 64<<20, "The largest document in bytes, unlimited if zero")
+	flag.StringVar(&cfg.jsonField, "json-field", "", "The path of the field holding the text of JSON documents")
 }
 
 func main() {
@@ -47,6 +107,10 @@ func main() {
 	}
 
 	raft.RegisterCommand(&command.WriteCommand{})
+	raft.RegisterCommand(&command.AddCommand{})
+	raft.RegisterCommand(&command.ImportCommand{})
+	raft.RegisterCommand(&command.ExpireCommand{})
+	raft.RegisterCommand(&command.EvictCommand{})
 
 	rand.Seed(time.Now().UnixNano())
 
@@ -56,6 +120,18 @@ func main() {
 		log.Fatal("Data path argument required")
 	}
 
+	if flag.Arg(0) == "restore" {
+		if flag.NArg() != 3 {
+			log.Fatal("Usage: deduper restore [archive] [data directory]")
+		}
+
+		if err := restore(flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Fatalf("Unable to restore: %v", err)
+		}
+
+		return
+	}
+
 	path := flag.Arg(0)
 	if err := os.MkdirAll(path, 0744); err != nil {
 		log.Fatalf("Unable to create path: %v", err)
@@ -63,8 +139,193 @@ func main() {
 
 	log.SetFlags(log.LstdFlags)
 
-	mh := minhash.New(cfg.bands, cfg.rows, cfg.shingles)
+	indexConfig, err := loadIndexConfig(path)
+	if err != nil {
+		log.Fatalf("Unable to load index config: %v", err)
+	}
+
+	index, err := newIndex(indexConfig, path)
+	if err != nil {
+		log.Fatalf("Invalid index config: %v", err)
+	}
+
+	s := server.New(path, cfg.host, cfg.port, index)
+	s.JSONField = cfg.jsonField
+	s.MaxBodySize = cfg.maxBodySize
+	s.Standalone = cfg.standalone
+	s.CheckpointInterval = cfg.checkpoint
+	s.ExpireInterval = cfg.expire
+	s.ForwardTimeout = cfg.forward
+	s.BackupDir = cfg.backupDir
+	s.AdminToken = cfg.adminToken
+
+	switch cfg.forwarding {
+	case "proxy":
+		s.Forwarding = middleware.Proxy
+	case "redirect":
+		s.Forwarding = middleware.Redirect
+	default:
+		log.Fatalf("Unknown forwarding %q", cfg.forwarding)
+	}
 
-	s := server.New(path, cfg.host, cfg.port, mh)
 	log.Fatal(s.ListenAndServe(cfg.leader))
 }
+
+// loadIndexConfig reads the index config from the data directory. If the
+// index is new, the config is fetched from the leader or, for a new cluster,
+// created from the command line flags. A data directory with a Raft log
+// but no config was created before the config was saved, so it is given
+// the config its documents were hashed with. It is saved so later runs
+// hash documents the same way.
+func loadIndexConfig(path string) (*minhash.Config, error) {
+	configPath := filepath.Join(path, "config.json")
+
+	c, err := minhash.ReadConfig(configPath)
+	if err == nil {
+		return c, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if fi, err := os.Stat(filepath.Join(path, "log")); err == nil && fi.Size() > 0 {
+		// the fixed seed and whitespace tokens, without normalization
+		c = &minhash.Config{
+			Bands:       cfg.bands,
+			Rows:        cfg.rows,
+			ShingleSize: cfg.shingles,
+			Normalizers: []string{},
+			Tokenizer:   "whitespace",
+		}
+
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+
+		log.Printf("Using the legacy index config for the existing log in %s", path)
+		return c, minhash.WriteConfig(configPath, c)
+	}
+
+	if cfg.leader != "" {
+		// every node must hash documents the same way
+		if c, err = fetchIndexConfig(cfg.leader, cfg.adminToken); err != nil {
+			return nil, err
+		}
+
+		return c, minhash.WriteConfig(configPath, c)
+	}
+
+	c = &minhash.Config{
+		Algorithm:      cfg.algorithm,
+		Distance:       cfg.distance,
+		Bands:          cfg.bands,
+		Rows:           cfg.rows,
+		ShingleSize:    cfg.shingles,
+		Tokenizer:      cfg.tokenizer,
+		StopWords:      cfg.stopWords,
+		ShortDocuments: cfg.short,
+		HashFamily:     cfg.hashFamily,
+		Seed:           cfg.seed,
+		Passages:       cfg.passages,
+		PassageSize:    cfg.passageSize,
+		StoreShingles:  cfg.storeShingles,
+		BBits:          cfg.bBits,
+		Storage:        cfg.storage,
+		MaxDocuments:   cfg.maxDocuments,
+		Eviction:       cfg.eviction,
+	}
+
+	if c.Seed == 0 {
+		if c.Seed, err = minhash.NewSeed(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.normalizers != "" {
+		c.Normalizers = strings.Split(cfg.normalizers, ",")
+	}
+
+	if cfg.ttl > 0 {
+		c.TTL = cfg.ttl.String()
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, minhash.WriteConfig(configPath, c)
+}
+
+// restore seeds a new data directory from a backup archive and checks
+// that the index it holds can be opened.
+func restore(archive string, path string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := backup.Restore(f, path); err != nil {
+		return err
+	}
+
+	c, err := minhash.ReadConfig(filepath.Join(path, "config.json"))
+	if err != nil {
+		return err
+	}
+
+	index, err := newIndex(c, path)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Restored %d documents to %s", index.Stats().Documents, path)
+
+	if closer, ok := index.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// newIndex creates the index for the algorithm of the config. Indexes
+// stored on disk are kept in the index directory of the data path.
+func newIndex(c *minhash.Config, path string) (server.Index, error) {
+	if c.Algorithm == minhash.AlgorithmSimHash {
+		return simhash.NewFromConfig(c)
+	}
+
+	return minhash.Open(c, filepath.Join(path, "index"))
+}
+
+// fetchIndexConfig fetches the index config of the cluster, with its
+// seed, from the leader with the shared secret of its admin routes.
+func fetchIndexConfig(leader string, token string) (*minhash.Config, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/admin/config", leader), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(middleware.AdminTokenHeader, token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("leader returned %s, start the follower with the leader's -admin-token", resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("leader returned %s", resp.Status)
+	}
+
+	c := &minhash.Config{}
+	if err := json.NewDecoder(resp.Body).Decode(c); err != nil {
+		return nil, err
+	}
+
+	return c, c.Validate()
+}
@@ -0,0 +1,51 @@
+package minhash
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// benchmarkText returns about 1MB of text made of random words.
+func benchmarkText() string {
+	r := rand.New(rand.NewSource(1))
+	words := strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+
+	var b strings.Builder
+	for b.Len() < 1<<20 {
+		b.WriteString(words[r.Intn(len(words))])
+		b.WriteByte(' ')
+	}
+
+	return b.String()
+}
+
+func benchmarkHashColumn(b *testing.B, family string) {
+	mh, err := NewFromConfig(&Config{Bands: 100, Rows: 2, ShingleSize: 2, HashFamily: family})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	text := benchmarkText()
+	b.SetBytes(int64(len(text)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := mh.hashColumn(strings.NewReader(text)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashColumn_Universal32(b *testing.B) {
+	benchmarkHashColumn(b, "universal32")
+}
+
+func BenchmarkHashColumn_Universal64(b *testing.B) {
+	benchmarkHashColumn(b, "universal64")
+}
+
+func BenchmarkHashColumn_OnePermutation(b *testing.B) {
+	benchmarkHashColumn(b, "oph")
+}
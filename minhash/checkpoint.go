@@ -0,0 +1,162 @@
+package minhash
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrSnapshotUnsupported is returned when snapshotting a MinHasher which
+// stores passages or shingles, as they have no place in a snapshot.
+var ErrSnapshotUnsupported = errors.New("indexes which store passages or shingles cannot be snapshotted")
+
+// ErrCheckpointUnsupported is returned when checkpointing a MinHasher which
+// stores passages or shingles, as they are only kept in memory.
+var ErrCheckpointUnsupported = errors.New("indexes which store passages or shingles cannot be checkpointed")
+
+// Checkpoint makes the documents added so far durable, so they are opened
+// rather than added again when the MinHasher is next opened from the same
+// directory. Documents stored on disk are flushed to their segments. Documents
+// stored in memory are written to a single segment, in the same format, which
+// replaces the last checkpoint. MinHashers which were not created by Open
+// are not checkpointed, and ErrCheckpointUnsupported is returned for those
+// which store passages or shingles.
+func (m *MinHasher) Checkpoint() error {
+	if s, ok := m.store.(*diskStore); ok {
+		return s.sync()
+	}
+
+	if m.config.Passages != "" || m.config.StoreShingles {
+		return ErrCheckpointUnsupported
+	}
+
+	if m.dir == "" {
+		return nil
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return writeCheckpoint(m.dir, m.store.(*memStore))
+}
+
+// Checkpointed returns the index of the latest raft log entry which is
+// durable in the checkpoint of the MinHasher, or 0 if there is none. The
+// entries up to it are not needed to open the MinHasher again.
+func (m *MinHasher) Checkpointed() (uint64, error) {
+	if m.dir == "" {
+		return 0, nil
+	}
+
+	mf, err := readManifest(m.dir)
+	return mf.Applied, err
+}
+
+// Snapshot writes the documents of the MinHasher, as they are when it is
+// called, to a new directory in the format of Checkpoint. The snapshot is
+// not tied to the raft log, so a MinHasher opened from it applies every
+// entry of a new log.
+func (m *MinHasher) Snapshot(dir string) error {
+	if m.config.Passages != "" || m.config.StoreShingles {
+		return ErrSnapshotUnsupported
+	}
+
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	refs := liveColumns([]columns{m.store})
+	if len(refs) == 0 {
+		return writeManifest(dir, manifest{})
+	}
+
+	next := manifest{
+		Segments: []string{segmentName(0)},
+		IDs:      m.store.ids(),
+		Next:     1,
+	}
+
+	if err := writeSegment(filepath.Join(dir, next.Segments[0]), m.layout, refs); err != nil {
+		return err
+	}
+
+	return writeManifest(dir, next)
+}
+
+// writeCheckpoint writes the columns of s which were not removed to a
+// new segment in dir and removes the segments of the previous checkpoint.
+func writeCheckpoint(dir string, s *memStore) error {
+	prev, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	if s.index == prev.Applied && (len(prev.Segments) > 0 || s.len() == 0) {
+		// nothing was added or removed since the last checkpoint
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return err
+	}
+
+	refs := liveColumns([]columns{s})
+	if len(refs) == 0 {
+		// every column was removed
+		next := manifest{Applied: s.index, Next: prev.Next}
+		if err := writeManifest(dir, next); err != nil {
+			return err
+		}
+
+		removeUnused(dir, next)
+		return nil
+	}
+
+	next := manifest{
+		Segments: []string{segmentName(prev.Next)},
+		Applied:  s.index,
+		IDs:      s.ids(),
+		Next:     prev.Next + 1,
+	}
+
+	if err := writeSegment(filepath.Join(dir, next.Segments[0]), s.layout, refs); err != nil {
+		return err
+	}
+
+	if err := writeManifest(dir, next); err != nil {
+		return err
+	}
+
+	removeUnused(dir, next)
+	return nil
+}
+
+// loadCheckpoint reads the columns of the checkpoint in dir into memory.
+// The store is empty if there is no checkpoint.
+func loadCheckpoint(dir string, l *layout) (*memStore, error) {
+	s := newMemStore(l)
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range m.Segments {
+		seg, err := openSegment(filepath.Join(dir, name), l)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < seg.len(); i++ {
+			s.add(seg.id(i), l.signature(seg.packed(i)), seg.band(i), seg.size(i), seg.stamp(i), 0)
+		}
+
+		seg.close()
+	}
+
+	s.index = m.Applied
+	return s, nil
+}
@@ -0,0 +1,389 @@
+package minhash
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/mauidude/deduper/text"
+)
+
+// The seed of indexes created before seeds were configurable.
+const legacySeed = 31
+
+// Config describes how a MinHasher hashes its documents. It is persisted
+// with the index so that documents added and queried later are always
+// hashed the same way.
+type Config struct {
+	// Algorithm is the algorithm used to find similar documents, either
+	// AlgorithmMinHash or AlgorithmSimHash. The default is AlgorithmMinHash.
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Bands is the number of bands used for locality sensitive hashing.
+	Bands int `json:"bands"`
+
+	// Rows is the number of hashes in each band.
+	Rows int `json:"rows"`
+
+	// ShingleSize is the number of tokens in each shingle.
+	ShingleSize int `json:"shingle_size"`
+
+	// Normalizers are the names of the text normalizers applied,
+	// in order, to each token before shingling.
+	Normalizers []string `json:"normalizers"`
+
+	// Tokenizer is the name of the tokenizer used to split text into
+	// tokens. The default is to split on whitespace.
+	Tokenizer string `json:"tokenizer,omitempty"`
+
+	// StopWords is the language of the stop words removed from the text.
+	// No words are removed if it is empty.
+	StopWords string `json:"stop_words,omitempty"`
+
+	// HashFamily is the name of the hash family used to compute
+	// signatures: universal32, universal64, oph or icws. The default
+	// is universal32.
+	HashFamily string `json:"hash_family,omitempty"`
+
+	// Seed seeds the random coefficients of the hash functions. Every
+	// index that should produce the same signatures must use the same
+	// seed. Zero is the fixed seed used before seeds were configurable.
+	Seed int64 `json:"seed"`
+
+	// ShortDocuments is how documents with fewer tokens than the shingle
+	// size are handled, either ShortShingle or ShortReject. The default
+	// is ShortShingle.
+	ShortDocuments string `json:"short_documents,omitempty"`
+
+	// Distance is the largest Hamming distance between the fingerprints
+	// of similar documents when using AlgorithmSimHash. Zero only
+	// matches equal fingerprints.
+	Distance int `json:"distance,omitempty"`
+
+	// Passages is how documents are split into passages which are
+	// hashed separately, either PassageParagraph or PassageTokens.
+	// Passages are not stored if it is empty.
+	Passages string `json:"passages,omitempty"`
+
+	// PassageSize is the number of tokens in each passage when using
+	// PassageTokens. The default is DefaultPassageSize.
+	PassageSize int `json:"passage_size,omitempty"`
+
+	// StoreShingles is whether the shingle hashes of each document are
+	// stored, so explanations of similarity include the shared shingles
+	// and the exact similarity. It costs 8 bytes per distinct shingle.
+	StoreShingles bool `json:"store_shingles,omitempty"`
+
+	// BBits is the number of low bits of each hash which are stored, one
+	// of 1, 2, 4, 8, 16 or 32. Storing fewer bits shrinks signatures at the
+	// cost of a less accurate similarity (b-bit minwise hashing). Bands are
+	// always computed from the whole hashes. The default is 32.
+	BBits int `json:"b_bits,omitempty"`
+
+	// Storage is where the signatures of documents are kept, either
+	// StorageMemory or StorageDisk. The default is StorageMemory.
+	Storage string `json:"storage,omitempty"`
+
+	// TTL is how long documents are kept before they expire, as a
+	// duration such as "168h". Documents can be added with their own
+	// TTL. Documents never expire if it is empty.
+	TTL string `json:"ttl,omitempty"`
+
+	// MaxDocuments is the most documents kept by the index. Once it holds
+	// more, documents are evicted as chosen by Eviction. There is no limit
+	// if it is zero.
+	MaxDocuments int `json:"max_documents,omitempty"`
+
+	// Eviction is how the documents evicted from a full index are chosen,
+	// either EvictFIFO or EvictLRU. The default is EvictFIFO. The matches
+	// used by EvictLRU are only known to the server which answered the
+	// queries and are not replicated, so it is only supported by
+	// standalone servers.
+	Eviction string `json:"eviction,omitempty"`
+}
+
+const (
+	// EvictFIFO evicts the documents which were added first.
+	EvictFIFO = "fifo"
+
+	// EvictLRU evicts the documents which were added or last
+	// matched by a query the longest time ago. It is only
+	// supported by standalone servers.
+	EvictLRU = "lru"
+)
+
+const (
+	// StorageMemory keeps signatures in memory. The index is
+	// rebuilt from the raft log when the server starts.
+	StorageMemory = "memory"
+
+	// StorageDisk keeps signatures in memory-mapped files, so the
+	// index can be larger than memory and is opened when the server
+	// starts rather than rebuilt.
+	StorageDisk = "disk"
+)
+
+const (
+	// AlgorithmMinHash finds similar documents by their Jaccard
+	// similarity using minhash and locality sensitive hashing.
+	AlgorithmMinHash = "minhash"
+
+	// AlgorithmSimHash finds similar documents by the Hamming
+	// distance between their SimHash fingerprints.
+	AlgorithmSimHash = "simhash"
+
+	// DefaultDistance is the default Hamming distance of the
+	// server's SimHash indexes.
+	DefaultDistance = 3
+)
+
+const (
+	// ShortShingle hashes a document with fewer tokens than the shingle
+	// size as a single shingle of all its tokens.
+	ShortShingle = "shingle"
+
+	// ShortReject rejects documents with fewer tokens than the shingle size.
+	ShortReject = "reject"
+)
+
+// Validate returns an error if the config cannot be used to create a MinHasher.
+func (c *Config) Validate() error {
+	if c.Bands < 1 || c.Rows < 1 {
+		return errors.New("bands and rows must be positive")
+	}
+
+	if c.ShingleSize < 1 {
+		return errors.New("shingle size must be positive")
+	}
+
+	switch c.Algorithm {
+	case "", AlgorithmMinHash, AlgorithmSimHash:
+	default:
+		return fmt.Errorf("unknown algorithm %q", c.Algorithm)
+	}
+
+	if c.Distance < 0 || c.Distance > 63 {
+		return errors.New("distance must be between 0 and 63")
+	}
+
+	switch c.Passages {
+	case "", PassageParagraph, PassageTokens:
+	default:
+		return fmt.Errorf("unknown passages %q", c.Passages)
+	}
+
+	if c.PassageSize < 0 {
+		return errors.New("passage size must not be negative")
+	}
+
+	switch c.BBits {
+	case 0, 1, 2, 4, 8, 16, 32:
+	default:
+		return errors.New("b bits must be 1, 2, 4, 8, 16 or 32")
+	}
+
+	switch c.Storage {
+	case "", StorageMemory:
+	case StorageDisk:
+		if c.Passages != "" || c.StoreShingles {
+			return errors.New("passages and shingles cannot be stored on disk")
+		}
+
+		if c.Algorithm == AlgorithmSimHash {
+			return errors.New("simhash indexes cannot be stored on disk")
+		}
+	default:
+		return fmt.Errorf("unknown storage %q", c.Storage)
+	}
+
+	switch c.ShortDocuments {
+	case "", ShortShingle, ShortReject:
+	default:
+		return fmt.Errorf("unknown short document handling %q", c.ShortDocuments)
+	}
+
+	if c.TTL != "" {
+		ttl, err := time.ParseDuration(c.TTL)
+		if err != nil {
+			return fmt.Errorf("invalid ttl: %v", err)
+		}
+
+		if ttl <= 0 {
+			return errors.New("ttl must be positive")
+		}
+
+		if c.Algorithm == AlgorithmSimHash {
+			return errors.New("simhash indexes cannot expire documents")
+		}
+	}
+
+	if c.MaxDocuments < 0 {
+		return errors.New("max documents must not be negative")
+	}
+
+	if c.MaxDocuments > 0 && c.Algorithm == AlgorithmSimHash {
+		return errors.New("simhash indexes cannot evict documents")
+	}
+
+	switch c.Eviction {
+	case "", EvictFIFO, EvictLRU:
+	default:
+		return fmt.Errorf("unknown eviction %q", c.Eviction)
+	}
+
+	return nil
+}
+
+// Compatible returns an error if documents hashed with the other config
+// cannot be added to an index with this config, because their signatures
+// are computed from different shingles, by different hash functions, or
+// have a different shape.
+func (c *Config) Compatible(other *Config) error {
+	switch {
+	case c.algorithm() != other.algorithm():
+		return fmt.Errorf("algorithm %q does not match %q", other.algorithm(), c.algorithm())
+	case c.HashSeed() != other.HashSeed():
+		return errors.New("hash seed does not match")
+	case c.Bands != other.Bands:
+		return fmt.Errorf("%d bands do not match %d", other.Bands, c.Bands)
+	case c.Rows != other.Rows:
+		return fmt.Errorf("%d rows do not match %d", other.Rows, c.Rows)
+	case c.hashFamily() != other.hashFamily():
+		return fmt.Errorf("hash family %q does not match %q", other.hashFamily(), c.hashFamily())
+	case c.bBits() != other.bBits():
+		return fmt.Errorf("%d b bits do not match %d", other.bBits(), c.bBits())
+	case c.ShingleSize != other.ShingleSize:
+		return fmt.Errorf("shingle size %d does not match %d", other.ShingleSize, c.ShingleSize)
+	case !equalNames(c.Normalizers, other.Normalizers):
+		return fmt.Errorf("normalizers %q do not match %q", other.Normalizers, c.Normalizers)
+	case c.tokenizer() != other.tokenizer():
+		return fmt.Errorf("tokenizer %q does not match %q", other.tokenizer(), c.tokenizer())
+	case c.StopWords != other.StopWords:
+		return fmt.Errorf("stop words %q do not match %q", other.StopWords, c.StopWords)
+	}
+
+	return nil
+}
+
+// ReadConfig reads a config previously written with WriteConfig.
+func ReadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// WriteConfig writes the config to the file at path.
+func WriteConfig(path string, c *Config) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// NewSeed returns a random, non-zero seed for a new index. It is read
+// from a cryptographically secure source so it cannot be guessed.
+func NewSeed() (int64, error) {
+	b := make([]byte, 8)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, err
+		}
+
+		if seed := int64(binary.LittleEndian.Uint64(b) >> 1); seed != 0 {
+			return seed, nil
+		}
+	}
+}
+
+// HashSeed returns the seed of the hash functions, which is the fixed
+// seed used before seeds were configurable if Seed is zero.
+func (c *Config) HashSeed() int64 {
+	if c.Seed == 0 {
+		return legacySeed
+	}
+
+	return c.Seed
+}
+
+// tokenizer returns the name of the tokenizer.
+func (c *Config) tokenizer() string {
+	if c.Tokenizer == "" {
+		return text.DefaultTokenizer
+	}
+
+	return c.Tokenizer
+}
+
+// equalNames returns true if the lists of names are the same.
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// algorithm returns the algorithm of the index.
+func (c *Config) algorithm() string {
+	if c.Algorithm == "" {
+		return AlgorithmMinHash
+	}
+
+	return c.Algorithm
+}
+
+// hashFamily returns the name of the hash family.
+func (c *Config) hashFamily() string {
+	if c.HashFamily == "" {
+		return DefaultHashFamily
+	}
+
+	return c.HashFamily
+}
+
+// TimeToLive returns how long documents are kept, or zero if they never
+// expire. The config must be valid.
+func (c *Config) TimeToLive() time.Duration {
+	ttl, _ := time.ParseDuration(c.TTL)
+	return ttl
+}
+
+// hashing returns a fingerprint of the hash functions and the bits of
+// each hash which are stored, so documents hashed by a differently
+// configured index can be told apart.
+func (c *Config) hashing() string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s:%d:%s:%d", c.algorithm(), c.HashSeed(), c.hashFamily(), c.bBits())
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// bBits returns the number of bits stored of each hash.
+func (c *Config) bBits() int {
+	if c.BBits == 0 {
+		return 32
+	}
+
+	return c.BBits
+}
@@ -0,0 +1,50 @@
+package minhash
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minhash")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	seed, err := NewSeed()
+	require.NoError(t, err)
+	assert.NotEqual(t, int64(0), seed)
+
+	c := &Config{Bands: 10, Rows: 2, ShingleSize: 2, Normalizers: []string{"casefold"}, Seed: seed}
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, WriteConfig(path, c))
+
+	read, err := ReadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, c, read)
+}
+
+func TestConfig_Seed(t *testing.T) {
+	doc := "the same document hashed by different indexes"
+
+	signature := func(seed int64) []uint32 {
+		mh, err := NewFromConfig(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: seed})
+		require.NoError(t, err)
+
+		sig, err := mh.Signature(strings.NewReader(doc))
+		require.NoError(t, err)
+
+		return sig
+	}
+
+	assert.Equal(t, signature(1), signature(1))
+	assert.NotEqual(t, signature(1), signature(2))
+
+	// zero is the seed used before seeds were configurable
+	assert.Equal(t, signature(0), signature(legacySeed))
+}
@@ -0,0 +1,807 @@
+package minhash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// the number of columns buffered in memory before they are flushed
+	defaultFlushSize = 10000
+
+	// segments are compacted into one when there are more than this
+	defaultMaxSegments = 8
+
+	// the name of the manifest file in the store's directory
+	manifestName = "manifest.json"
+)
+
+// manifest records the segments of a diskStore. It is replaced
+// atomically after each flush and compaction.
+type manifest struct {
+	// Segments are the names of the segment files, oldest first.
+	Segments []string `json:"segments"`
+
+	// Applied is the index of the latest raft log entry in the segments.
+	Applied uint64 `json:"applied"`
+
+	// IDs is the number of distinct ids of the columns
+	// of the segments which were not removed.
+	IDs int `json:"ids"`
+
+	// Removed are the columns of each segment which were removed
+	// after it was written.
+	Removed map[string][]int `json:"removed,omitempty"`
+
+	// Next is the number of the next segment file.
+	Next int `json:"next"`
+}
+
+// diskStore keeps columns in memory-mapped segment files, so the index can
+// be larger than memory and is not rebuilt when the server restarts. New
+// columns are added to an in-memory buffer, which is flushed to a new
+// segment in the background once it is full. Segments are compacted into
+// one in the background when there are too many of them, or when too many
+// of their columns were removed.
+type diskStore struct {
+	*layout
+
+	// the directory of the segment files
+	dir string
+
+	// locks the fields below, it is shared with the MinHasher
+	// so segments are never unmapped while they are being read
+	mutex *sync.RWMutex
+
+	// the columns of the store are those of the segments, then
+	// of the buffers waiting to be flushed, then of the active buffer
+	segments []*segment
+	frozen   []*memStore
+	active   *memStore
+
+	// the lists above in order, the number of the first column
+	// of each and the number of columns before the active buffer,
+	// rebuilt by reindex whenever the lists change
+	all    []columns
+	starts []int
+	fixed  int
+
+	// the last manifest written
+	manifest manifest
+
+	// the number of distinct ids in the store, and in the segments,
+	// of the columns which were not removed
+	distinct   int
+	segmentIDs int
+
+	// the index of the latest raft log entry added
+	index uint64
+
+	// the number of readers holding the column numbers,
+	// the segments are not compacted while there are any
+	holds int
+
+	// whether columns of the segments were removed
+	// since the manifest was written
+	unsaved bool
+
+	// flush the active buffer when it holds flushSize columns and
+	// compact the segments when there are more than maxSegments
+	flushSize   int
+	maxSegments int
+
+	// signals the background worker and waits for it to stop
+	work chan struct{}
+	done chan struct{}
+
+	// signalled, with the mutex held, when a buffer is flushed
+	// or the worker fails
+	flushed *sync.Cond
+
+	// the last error of the background worker
+	err error
+}
+
+// openDiskStore opens the store in dir, creating it if needed. The mutex
+// must be held by readers of the store while they use its columns.
+func openDiskStore(dir string, l *layout, mutex *sync.RWMutex) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return nil, err
+	}
+
+	s := &diskStore{
+		layout:      l,
+		dir:         dir,
+		mutex:       mutex,
+		active:      newMemStore(l),
+		flushSize:   defaultFlushSize,
+		maxSegments: defaultMaxSegments,
+		work:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		flushed:     sync.NewCond(mutex),
+	}
+
+	var err error
+	if s.manifest, err = readManifest(dir); err != nil {
+		return nil, err
+	}
+
+	for _, name := range s.manifest.Segments {
+		seg, err := openSegment(filepath.Join(dir, name), l)
+		if err != nil {
+			s.closeSegments()
+			return nil, err
+		}
+
+		for _, i := range s.manifest.Removed[name] {
+			if i < 0 || i >= seg.len() {
+				s.closeSegments()
+				seg.close()
+				return nil, fmt.Errorf("removed column %d out of range of segment %s", i, name)
+			}
+
+			seg.mark(i, seg.len())
+		}
+
+		s.segments = append(s.segments, seg)
+	}
+
+	s.reindex()
+	s.index = s.manifest.Applied
+	s.distinct = s.manifest.IDs
+	s.segmentIDs = s.manifest.IDs
+	s.removeUnused()
+
+	go s.worker()
+
+	return s, nil
+}
+
+// removeUnused removes segment files which are not in the manifest,
+// left behind by a crash during a flush or compaction.
+func (s *diskStore) removeUnused() {
+	removeUnused(s.dir, s.manifest)
+}
+
+// parts returns the lists of columns of the store in order.
+func (s *diskStore) parts() []columns {
+	return s.all
+}
+
+// reindex rebuilds the lists of columns and the numbers of their first
+// columns. It must be called after segments or buffers are added or removed.
+func (s *diskStore) reindex() {
+	n := len(s.segments) + len(s.frozen) + 1
+	all := make([]columns, 0, n)
+	starts := make([]int, 0, n)
+
+	offset := 0
+	for _, seg := range s.segments {
+		all = append(all, seg)
+		starts = append(starts, offset)
+		offset += seg.len()
+	}
+
+	for _, m := range s.frozen {
+		all = append(all, m)
+		starts = append(starts, offset)
+		offset += m.len()
+	}
+
+	s.all = append(all, s.active)
+	s.starts = append(starts, offset)
+	s.fixed = offset
+}
+
+// locate returns the list holding the ith column and its index in the list.
+func (s *diskStore) locate(i int) (columns, int) {
+	if i < 0 || i >= s.len() {
+		panic(fmt.Sprintf("column %d out of range", i))
+	}
+
+	if i >= s.fixed {
+		return s.active, i - s.fixed
+	}
+
+	k := sort.SearchInts(s.starts, i+1) - 1
+	return s.all[k], i - s.starts[k]
+}
+
+func (s *diskStore) len() int {
+	return s.fixed + s.active.len()
+}
+
+func (s *diskStore) add(id string, signature vector, bands vector, size int, st stamp, index uint64) {
+	if s.last(id) < 0 {
+		s.distinct++
+	}
+
+	s.active.add(id, signature, bands, size, st, index)
+	if index > s.index {
+		s.index = index
+	}
+
+	if s.active.len() >= s.flushSize {
+		s.freeze()
+	}
+}
+
+func (s *diskStore) remove(i int, index uint64) {
+	if index > s.index {
+		s.index = index
+	}
+
+	p, j := s.locate(i)
+	if p.removed(j) {
+		return
+	}
+
+	id := p.id(j)
+	switch p := p.(type) {
+	case *segment:
+		p.mark(j, p.len())
+		s.unsaved = true
+		if !s.inSegments(id) {
+			s.segmentIDs--
+		}
+
+		if s.compactable() {
+			s.signal()
+		}
+	case *memStore:
+		p.remove(j, 0)
+	}
+
+	if s.last(id) < 0 {
+		s.distinct--
+	}
+}
+
+func (s *diskStore) hold() {
+	s.holds++
+}
+
+func (s *diskStore) release() {
+	s.holds--
+	if s.holds == 0 && s.compactable() {
+		s.signal()
+	}
+}
+
+// freeze queues the active buffer to be flushed by the worker.
+func (s *diskStore) freeze() {
+	if s.active.len() > 0 {
+		s.frozen = append(s.frozen, s.active)
+		s.active = newMemStore(s.layout)
+		s.reindex()
+	}
+
+	if len(s.frozen) > 0 {
+		s.signal()
+	}
+}
+
+// signal wakes the worker.
+func (s *diskStore) signal() {
+	select {
+	case s.work <- struct{}{}:
+	default:
+		// the worker has yet to see the last signal
+	}
+}
+
+// compactable returns true if the segments should be compacted, because
+// there are too many or because more than a quarter of their columns
+// were removed. It is false while the column numbers are held.
+func (s *diskStore) compactable() bool {
+	if s.holds > 0 {
+		return false
+	}
+
+	n, dead := 0, 0
+	for _, seg := range s.segments {
+		n += seg.len()
+		dead += seg.dead()
+	}
+
+	return len(s.segments) > s.maxSegments || dead > 0 && 4*dead > n
+}
+
+func (s *diskStore) applied() uint64 {
+	return s.index
+}
+
+func (s *diskStore) ids() int {
+	return s.distinct
+}
+
+func (s *diskStore) id(i int) string {
+	p, i := s.locate(i)
+	return p.id(i)
+}
+
+func (s *diskStore) size(i int) int {
+	p, i := s.locate(i)
+	return p.size(i)
+}
+
+func (s *diskStore) band(i int) vector {
+	p, i := s.locate(i)
+	return p.band(i)
+}
+
+func (s *diskStore) packed(i int) []uint32 {
+	p, i := s.locate(i)
+	return p.packed(i)
+}
+
+func (s *diskStore) stamp(i int) stamp {
+	p, i := s.locate(i)
+	return p.stamp(i)
+}
+
+func (s *diskStore) removed(i int) bool {
+	p, i := s.locate(i)
+	return p.removed(i)
+}
+
+func (s *diskStore) dead() int {
+	n := 0
+	for _, p := range s.parts() {
+		n += p.dead()
+	}
+
+	return n
+}
+
+func (s *diskStore) last(id string) int {
+	for k := len(s.all) - 1; k >= 0; k-- {
+		if i := s.all[k].last(id); i >= 0 {
+			return s.starts[k] + i
+		}
+	}
+
+	return -1
+}
+
+func (s *diskStore) candidates(bands vector) []int {
+	var c []int
+
+	for k, p := range s.all {
+		for _, i := range p.candidates(bands) {
+			c = append(c, s.starts[k]+i)
+		}
+	}
+
+	return c
+}
+
+func (s *diskStore) bytes() int64 {
+	n := int64(0)
+	for _, p := range s.parts() {
+		n += p.bytes()
+	}
+
+	return n
+}
+
+// sync flushes the buffered columns and waits until they, and the
+// columns which were removed, are written.
+func (s *diskStore) sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.err = nil
+	s.freeze()
+	if s.unsaved {
+		s.signal()
+	}
+
+	for (len(s.frozen) > 0 || s.unsaved) && s.err == nil {
+		s.flushed.Wait()
+	}
+
+	return s.err
+}
+
+// close flushes the buffered columns and closes the segments.
+func (s *diskStore) close() error {
+	s.mutex.Lock()
+	s.freeze()
+	s.mutex.Unlock()
+
+	close(s.work)
+	<-s.done
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closeSegments()
+	return s.err
+}
+
+func (s *diskStore) closeSegments() {
+	for _, seg := range s.segments {
+		seg.close()
+	}
+
+	s.segments = nil
+	s.reindex()
+}
+
+// worker flushes and compacts in the background until the store is closed.
+func (s *diskStore) worker() {
+	defer close(s.done)
+
+	for range s.work {
+		s.background()
+	}
+
+	// flush what is left before closing
+	s.background()
+}
+
+// background flushes the full buffers, compacts the segments if
+// needed and records the columns which were removed.
+func (s *diskStore) background() {
+	for {
+		s.mutex.RLock()
+		if len(s.frozen) == 0 {
+			s.mutex.RUnlock()
+			break
+		}
+		m := s.frozen[0]
+		s.mutex.RUnlock()
+
+		if err := s.flush(m); err != nil {
+			// the buffer stays in memory and is retried later
+			s.setErr(err)
+			return
+		}
+	}
+
+	s.mutex.RLock()
+	compact := s.compactable()
+	s.mutex.RUnlock()
+
+	if compact {
+		if err := s.compact(); err != nil {
+			s.setErr(err)
+			return
+		}
+	}
+
+	if err := s.saveRemoved(); err != nil {
+		s.setErr(err)
+	}
+}
+
+// saveRemoved writes the manifest if columns of the segments
+// were removed since it was written.
+func (s *diskStore) saveRemoved() error {
+	s.mutex.Lock()
+	if !s.unsaved {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	next := s.manifest
+	next.Removed = s.removedColumns()
+	next.IDs = s.segmentIDs
+	s.unsaved = false
+	s.mutex.Unlock()
+
+	err := writeManifest(s.dir, next)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil {
+		s.unsaved = true
+		return err
+	}
+
+	s.manifest = next
+	s.flushed.Broadcast()
+	return nil
+}
+
+func (s *diskStore) setErr(err error) {
+	s.mutex.Lock()
+	s.err = err
+	s.flushed.Broadcast()
+	s.mutex.Unlock()
+}
+
+// flush writes the oldest frozen buffer, m, to a new segment. All its
+// columns are written, so the column numbers do not change, and those
+// which were removed are recorded in the manifest.
+func (s *diskStore) flush(m *memStore) error {
+	// only the worker changes the segments and manifest, but columns
+	// can be removed while the segment is written, so the manifest
+	// records them as they are now
+	s.mutex.RLock()
+	next := s.manifest
+	name := segmentName(next.Next)
+	next.Next++
+	next.Removed = s.removedColumns()
+	next.IDs = s.segmentIDs + s.newIDs(m)
+
+	if m.dead() > 0 {
+		next.Removed[name] = m.list()
+	}
+	s.mutex.RUnlock()
+
+	refs := make([]columnRef, m.len())
+	for i := range refs {
+		refs[i] = columnRef{m, i}
+	}
+
+	path := filepath.Join(s.dir, name)
+	if err := writeSegment(path, s.layout, refs); err != nil {
+		return err
+	}
+
+	seg, err := openSegment(path, s.layout)
+	if err != nil {
+		return err
+	}
+
+	next.Segments = append(append([]string{}, next.Segments...), name)
+	if m.index > next.Applied {
+		next.Applied = m.index
+	}
+
+	if err := writeManifest(s.dir, next); err != nil {
+		seg.close()
+		os.Remove(path)
+		return err
+	}
+
+	s.mutex.Lock()
+	s.segmentIDs += s.newIDs(m)
+	seg.tombstones = m.tombstones
+	s.segments = append(s.segments, seg)
+	s.frozen = s.frozen[1:]
+	s.reindex()
+	s.manifest = next
+	s.flushed.Broadcast()
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// newIDs returns the number of distinct ids of the columns
+// of m which were not removed and are not in the segments.
+func (s *diskStore) newIDs(m *memStore) int {
+	n := 0
+	for name, id := range m.names {
+		if m.live[name] > 0 && !s.inSegments(id) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// removedColumns returns the removed columns of each segment.
+func (s *diskStore) removedColumns() map[string][]int {
+	removed := make(map[string][]int)
+	for _, seg := range s.segments {
+		if seg.dead() > 0 {
+			removed[filepath.Base(seg.path)] = seg.list()
+		}
+	}
+
+	return removed
+}
+
+// compact merges all the segments into one, leaving out the
+// columns which were removed.
+func (s *diskStore) compact() error {
+	s.mutex.RLock()
+	if s.holds > 0 {
+		// the columns were held after the worker was signalled
+		s.mutex.RUnlock()
+		return nil
+	}
+
+	old := s.segments
+	parts := make([]columns, len(old))
+	for i, seg := range old {
+		parts[i] = seg
+	}
+
+	// the new index of each column, or -1 if it is left out
+	refs := liveColumns(parts)
+	renumber := make([][]int, len(old))
+	for i, seg := range old {
+		renumber[i] = make([]int, seg.len())
+		for j := range renumber[i] {
+			renumber[i][j] = -1
+		}
+	}
+
+	k := 0
+	for c, r := range refs {
+		for old[k] != r.part {
+			k++
+		}
+		renumber[k][r.i] = c
+	}
+
+	dead := make([]int, len(old))
+	for i, seg := range old {
+		dead[i] = seg.dead()
+	}
+
+	next := s.manifest
+	next.Removed = nil
+	next.IDs = s.segmentIDs
+	s.mutex.RUnlock()
+
+	if len(refs) == 0 {
+		// every column was removed
+		next.Segments = nil
+		if err := writeManifest(s.dir, next); err != nil {
+			return err
+		}
+
+		s.mutex.Lock()
+		s.segments = s.segments[len(old):]
+		s.reindex()
+		s.manifest = next
+		s.mutex.Unlock()
+
+		s.removeSegments(old)
+		return nil
+	}
+
+	name := segmentName(next.Next)
+	next.Next++
+
+	path := filepath.Join(s.dir, name)
+	if err := writeSegment(path, s.layout, refs); err != nil {
+		return err
+	}
+
+	seg, err := openSegment(path, s.layout)
+	if err != nil {
+		return err
+	}
+
+	next.Segments = []string{name}
+	if err := writeManifest(s.dir, next); err != nil {
+		seg.close()
+		os.Remove(path)
+		return err
+	}
+
+	s.mutex.Lock()
+	// columns removed while the segment was written are
+	// marked again, and recorded in the next manifest
+	for i, o := range old {
+		if o.dead() == dead[i] {
+			continue
+		}
+
+		for _, j := range o.list() {
+			if c := renumber[i][j]; c >= 0 {
+				seg.mark(c, seg.len())
+			}
+		}
+	}
+
+	s.segments = append([]*segment{seg}, s.segments[len(old):]...)
+	s.reindex()
+	s.manifest = next
+	s.mutex.Unlock()
+
+	s.removeSegments(old)
+	return nil
+}
+
+// removeSegments closes and removes segments which no reader can reach.
+func (s *diskStore) removeSegments(old []*segment) {
+	for _, seg := range old {
+		seg.close()
+		os.Remove(seg.path)
+	}
+}
+
+// inSegments returns true if a column of the segments has the given id.
+func (s *diskStore) inSegments(id string) bool {
+	for _, seg := range s.segments {
+		if seg.last(id) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readManifest reads the manifest in dir. It is empty if
+// there is no manifest.
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return m, err
+	}
+
+	return m, json.Unmarshal(b, &m)
+}
+
+// writeManifest atomically and durably replaces the manifest in dir, so
+// the entries of the raft log it covers can be dropped once it returns.
+func writeManifest(dir string, m manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, manifestName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir syncs the directory, so the files renamed into it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// removeUnused removes the segment files in dir which are not in m.
+func removeUnused(dir string, m manifest) {
+	used := make(map[string]bool)
+	for _, name := range m.Segments {
+		used[name] = true
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.seg*"))
+	for _, path := range names {
+		if !used[filepath.Base(path)] {
+			os.Remove(path)
+		}
+	}
+}
+
+// segmentName returns the name of the nth segment file.
+func segmentName(n int) string {
+	return fmt.Sprintf("%08d.seg", n)
+}
@@ -0,0 +1,229 @@
+package minhash
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "segment")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l := newLayout(4, 8, 2)
+	m := newMemStore(l)
+	m.add("b", vector{1, 2, 3, 4}, vector{10, 20}, 5, stamp{}, 0)
+	m.add("a", vector{5, 6, 7, 8}, vector{11, 20}, 6, stamp{added: 1 << 40, expires: 1<<40 + 1}, 0)
+	m.add("b", vector{9, 10, 11, 12}, vector{10, 21}, 7, stamp{}, 0)
+
+	path := filepath.Join(dir, "1.seg")
+	require.NoError(t, writeSegment(path, l, liveColumns([]columns{m})))
+
+	s, err := openSegment(path, l)
+	require.NoError(t, err)
+	defer s.close()
+
+	assert.Equal(t, 3, s.len())
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, m.id(i), s.id(i))
+		assert.Equal(t, m.size(i), s.size(i))
+		assert.Equal(t, m.band(i), s.band(i))
+		assert.Equal(t, m.packed(i), s.packed(i))
+		assert.Equal(t, m.stamp(i), s.stamp(i))
+	}
+
+	assert.Equal(t, 2, s.last("b"))
+	assert.Equal(t, 1, s.last("a"))
+	assert.Equal(t, -1, s.last("c"))
+	assert.Equal(t, -1, s.last(""))
+
+	assert.Equal(t, []int{0, 2}, s.candidates(vector{10, 0}))
+	assert.Equal(t, []int{0, 1}, s.candidates(vector{0, 20}))
+	assert.Len(t, s.candidates(vector{0, 0}), 0)
+
+	// removed columns are neither the last of their id nor candidates
+	assert.True(t, s.mark(2, s.len()))
+	assert.False(t, s.mark(2, s.len()))
+	assert.Equal(t, 0, s.last("b"))
+	assert.Equal(t, []int{0}, s.candidates(vector{10, 0}))
+	assert.Equal(t, 1, s.dead())
+
+	// segments must have the layout of the index
+	_, err = openSegment(path, newLayout(4, 32, 2))
+	assert.Error(t, err)
+}
+
+func TestMinHasher_Disk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Config{Bands: 20, Rows: 2, ShingleSize: 2, Storage: StorageDisk}
+	mh, err := Open(c, dir)
+	require.NoError(t, err)
+
+	store := mh.store.(*diskStore)
+	store.flushSize = 3
+	store.maxSegments = 2
+
+	r := rand.New(rand.NewSource(7))
+	docs := make([]string, 10)
+	for i := range docs {
+		docs[i] = randomWords(r, 20)
+		hashed, err := mh.Hash(strings.NewReader(docs[i]))
+		require.NoError(t, err)
+		require.NoError(t, mh.AddHashedAt(strconv.Itoa(i%8), hashed, Stamp{}, uint64(i+1)))
+	}
+
+	// a checkpoint waits for the buffered columns to be flushed
+	require.NoError(t, mh.Checkpoint())
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"applied": 10`)
+
+	// the columns are found in the segments and buffers in order
+	mh.mutex.RLock()
+	if assert.Equal(t, 10, store.len()) {
+		for i := range docs {
+			assert.Equal(t, strconv.Itoa(i%8), store.id(i))
+		}
+	}
+	mh.mutex.RUnlock()
+
+	// the segments are compacted before the store is closed
+	require.NoError(t, mh.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	assert.True(t, len(segments) <= 2)
+
+	// the documents are opened, not rebuilt
+	mh, err = Open(c, dir)
+	require.NoError(t, err)
+	defer mh.Close()
+
+	stats := mh.Stats()
+	assert.Equal(t, 10, stats.Documents)
+	assert.Equal(t, 8, stats.IDs)
+
+	for i, doc := range docs {
+		results, err := mh.FindSimilar(strings.NewReader(doc), 1)
+		require.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, strconv.Itoa(i%8), results[0].ID)
+		}
+	}
+
+	// entries of the raft log which were stored are skipped when replayed
+	require.NoError(t, mh.AddAt("replayed", strings.NewReader(docs[0]), 10))
+	assert.False(t, mh.Contains("replayed"))
+
+	require.NoError(t, mh.AddAt("new", strings.NewReader(docs[0]), 11))
+	assert.True(t, mh.Contains("new"))
+
+	e, err := mh.Explain("1", strings.NewReader(docs[9]))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1.0, e.Similarity)
+	}
+}
+
+func TestMinHasher_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Config{Bands: 20, Rows: 2, ShingleSize: 2}
+	mh, err := Open(c, dir)
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(7))
+	docs := make([]string, 5)
+	for i := range docs {
+		docs[i] = randomWords(r, 20)
+		require.NoError(t, mh.AddAt(strconv.Itoa(i), strings.NewReader(docs[i]), uint64(i+1)))
+	}
+
+	require.NoError(t, mh.Checkpoint())
+	require.NoError(t, mh.AddAt("5", strings.NewReader(randomWords(r, 20)), 6))
+	require.NoError(t, mh.Checkpoint())
+
+	// only the last checkpoint is kept
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	assert.Len(t, segments, 1)
+
+	mh, err = Open(c, dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, mh.Stats().Documents)
+	for i, doc := range docs {
+		results, err := mh.FindSimilar(strings.NewReader(doc), 1)
+		require.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, strconv.Itoa(i), results[0].ID)
+		}
+	}
+
+	// entries in the checkpoint are skipped when the log is replayed
+	require.NoError(t, mh.AddAt("replayed", strings.NewReader(docs[0]), 6))
+	assert.False(t, mh.Contains("replayed"))
+}
+
+func TestMinHasher_Snapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Config{Bands: 20, Rows: 2, ShingleSize: 2, Storage: StorageDisk}
+	mh, err := Open(c, filepath.Join(dir, "index"))
+	require.NoError(t, err)
+	defer mh.Close()
+
+	mh.store.(*diskStore).flushSize = 3
+
+	r := rand.New(rand.NewSource(7))
+	docs := make([]string, 10)
+	for i := range docs {
+		docs[i] = randomWords(r, 20)
+		require.NoError(t, mh.AddAt(strconv.Itoa(i), strings.NewReader(docs[i]), uint64(i+1)))
+	}
+
+	// the snapshot holds the segments and the buffered columns
+	require.NoError(t, mh.Snapshot(filepath.Join(dir, "snapshot")))
+
+	for _, storage := range []string{StorageMemory, StorageDisk} {
+		c.Storage = storage
+		restored, err := Open(c, filepath.Join(dir, "snapshot"))
+		require.NoError(t, err)
+
+		assert.Equal(t, 10, restored.Stats().Documents, storage)
+		for i, doc := range docs {
+			results, err := restored.FindSimilar(strings.NewReader(doc), 1)
+			require.NoError(t, err)
+			if assert.Len(t, results, 1, storage) {
+				assert.Equal(t, strconv.Itoa(i), results[0].ID, storage)
+			}
+		}
+
+		// the snapshot is not tied to the log it was taken from
+		require.NoError(t, restored.AddAt("new", strings.NewReader(docs[0]), 1))
+		assert.True(t, restored.Contains("new"), storage)
+		require.NoError(t, restored.Close())
+	}
+
+	assert.NoError(t, New(10, 2, 2).Snapshot(filepath.Join(dir, "memory")))
+
+	passages, err := NewFromConfig(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Passages: PassageParagraph})
+	require.NoError(t, err)
+	assert.Equal(t, ErrSnapshotUnsupported, passages.Snapshot(filepath.Join(dir, "unsupported")))
+	assert.Equal(t, ErrCheckpointUnsupported, passages.Checkpoint())
+}
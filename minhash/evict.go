@@ -0,0 +1,157 @@
+package minhash
+
+import (
+	"sort"
+	"time"
+)
+
+// Evictions returns whether the MinHasher holds more than MaxDocuments
+// documents, so the leader should evict some with EvictAt. With EvictLRU
+// it also returns the ids to evict, least recently added or matched first,
+// as the matches are only known to this MinHasher. With EvictFIFO the oldest
+// documents are evicted, so no ids are returned.
+func (m *MinHasher) Evictions() ([]string, bool) {
+	max := m.config.MaxDocuments
+	if max == 0 {
+		return nil, false
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	excess := m.store.len() - m.store.dead() - max
+	if excess <= 0 {
+		return nil, false
+	}
+
+	if m.matched == nil {
+		return nil, true
+	}
+
+	// when each id was last added or matched, and its number of columns,
+	// in the order the ids were first added
+	type use struct {
+		id      string
+		last    int64
+		columns int
+	}
+
+	byID := make(map[string]*use)
+	var uses []*use
+
+	m.matchMutex.Lock()
+	for i := 0; i < m.store.len(); i++ {
+		if m.store.removed(i) {
+			continue
+		}
+
+		id := m.store.id(i)
+		u, ok := byID[id]
+		if !ok {
+			u = &use{id: id, last: m.matched[id]}
+			byID[id] = u
+			uses = append(uses, u)
+		}
+
+		u.columns++
+		if added := m.store.stamp(i).added; added > u.last {
+			u.last = added
+		}
+	}
+	m.matchMutex.Unlock()
+
+	// ids which were used at the same time are evicted oldest first
+	sort.SliceStable(uses, func(a, b int) bool { return uses[a].last < uses[b].last })
+
+	var ids []string
+	for _, u := range uses {
+		if excess <= 0 {
+			break
+		}
+
+		ids = append(ids, u.id)
+		excess -= u.columns
+	}
+
+	return ids, true
+}
+
+// EvictAt removes documents, as the raft log entry with the given index,
+// until at most max are left, and returns how many were removed. The
+// documents with the given ids are removed first, in order, then the
+// oldest documents. The ids are chosen by the leader, so every server
+// removes the same documents. Nothing is removed if the entry was
+// applied before.
+func (m *MinHasher) EvictAt(max int, ids []string, index uint64) int {
+	if m.appliedAt(index) {
+		return 0
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	live := m.store.len() - m.store.dead()
+	var removed []string
+
+	for _, id := range ids {
+		for live > max {
+			i := m.store.last(id)
+			if i < 0 {
+				break
+			}
+
+			m.store.remove(i, index)
+			removed = append(removed, id)
+			live--
+		}
+	}
+
+	for i := 0; i < m.store.len() && live > max; i++ {
+		if !m.store.removed(i) {
+			removed = append(removed, m.store.id(i))
+			m.store.remove(i, index)
+			live--
+		}
+	}
+
+	if len(removed) > 0 {
+		m.forget(removed)
+		m.purge()
+	}
+
+	return len(removed)
+}
+
+// touch records that the documents were matched, if
+// documents are evicted by EvictLRU.
+func (m *MinHasher) touch(matches []Match) {
+	if m.matched == nil || len(matches) == 0 {
+		return
+	}
+
+	now := time.Now().UnixNano()
+
+	m.matchMutex.Lock()
+	defer m.matchMutex.Unlock()
+
+	for _, match := range matches {
+		m.matched[match.ID] = now
+	}
+}
+
+// forget forgets the matches of the ids which were removed and have
+// no documents left. The lock must be held.
+func (m *MinHasher) forget(ids []string) {
+	if m.matched == nil {
+		return
+	}
+
+	m.matchMutex.Lock()
+	defer m.matchMutex.Unlock()
+
+	for _, id := range ids {
+		if m.store.last(id) < 0 {
+			delete(m.matched, id)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package minhash
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinHasher_EvictAt(t *testing.T) {
+	for _, eviction := range []string{EvictFIFO, EvictLRU} {
+		c := &Config{Bands: 20, Rows: 2, ShingleSize: 2, MaxDocuments: 3, Eviction: eviction}
+		mh, err := NewFromConfig(c)
+		require.NoError(t, err)
+
+		r := rand.New(rand.NewSource(7))
+		docs := make([]string, 5)
+		add := func(i int) {
+			docs[i] = randomWords(r, 20)
+			hashed, err := mh.Hash(strings.NewReader(docs[i]))
+			require.NoError(t, err)
+
+			added := time.Unix(int64(1000+i), 0)
+			require.NoError(t, mh.AddHashedAt(strconv.Itoa(i), hashed, NewStamp(added, 0), uint64(i+1)))
+		}
+
+		for i := 0; i < 3; i++ {
+			add(i)
+		}
+
+		_, ok := mh.Evictions()
+		assert.False(t, ok, eviction)
+
+		// the first document is matched after the others were added
+		_, err = mh.FindSimilar(strings.NewReader(docs[0]), 1)
+		require.NoError(t, err)
+
+		add(3)
+		add(4)
+
+		ids, ok := mh.Evictions()
+		assert.True(t, ok, eviction)
+
+		assert.Equal(t, 2, mh.EvictAt(c.MaxDocuments, ids, 6), eviction)
+		assert.Equal(t, 3, mh.Stats().Documents, eviction)
+
+		_, ok = mh.Evictions()
+		assert.False(t, ok, eviction)
+
+		// the entry is not applied again when the log is replayed
+		assert.Equal(t, 0, mh.EvictAt(0, nil, 6), eviction)
+
+		if eviction == EvictFIFO {
+			assert.Len(t, ids, 0)
+			assert.False(t, mh.Contains("0"))
+			assert.False(t, mh.Contains("1"))
+			assert.True(t, mh.Contains("2"))
+		} else {
+			assert.Equal(t, []string{"1", "2"}, ids)
+			assert.True(t, mh.Contains("0"))
+			assert.False(t, mh.Contains("1"))
+			assert.False(t, mh.Contains("2"))
+		}
+
+		assert.True(t, mh.Contains("3"), eviction)
+		assert.True(t, mh.Contains("4"), eviction)
+	}
+}
+
+func TestConfig_MaxDocuments(t *testing.T) {
+	c := &Config{Bands: 10, Rows: 2, ShingleSize: 2, MaxDocuments: 10, Eviction: EvictLRU}
+	assert.NoError(t, c.Validate())
+
+	c.Eviction = "random"
+	assert.Error(t, c.Validate())
+
+	c.Eviction = ""
+	c.MaxDocuments = -1
+	assert.Error(t, c.Validate())
+
+	c.MaxDocuments = 10
+	c.Algorithm = AlgorithmSimHash
+	assert.Error(t, c.Validate())
+}
@@ -0,0 +1,134 @@
+package minhash
+
+import "time"
+
+// Stamp is when the leader added a document and when it expires.
+// Either is the zero time if it is unknown or never.
+type Stamp struct {
+	Added   time.Time
+	Expires time.Time
+}
+
+// NewStamp returns the stamp of a document added at the given
+// time which expires after ttl, or never if ttl is zero.
+func NewStamp(added time.Time, ttl time.Duration) Stamp {
+	st := Stamp{Added: added}
+	if ttl > 0 {
+		st.Expires = added.Add(ttl)
+	}
+
+	return st
+}
+
+// stamp returns the stamp as it is stored.
+func (st Stamp) stamp() stamp {
+	return stamp{
+		added:   unixNano(st.Added),
+		expires: unixNano(st.Expires),
+	}
+}
+
+// Stamp returns the stamp as it is returned.
+func (st stamp) Stamp() Stamp {
+	return Stamp{
+		Added:   fromUnixNano(st.added),
+		Expires: fromUnixNano(st.expires),
+	}
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n).UTC()
+}
+
+// Expiring returns true if a document of the MinHasher has
+// expired at the given time, so ExpireAt would remove it.
+func (m *MinHasher) Expiring(now time.Time) bool {
+	t := now.UnixNano()
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for i := 0; i < m.store.len(); i++ {
+		if !m.store.removed(i) && m.store.stamp(i).expired(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExpireAt removes the documents which expire at or before the given time,
+// as the raft log entry with the given index, and returns how many were
+// removed. The time is decided by the leader, so every server removes the
+// same documents. Nothing is removed if the entry was applied before.
+func (m *MinHasher) ExpireAt(before time.Time, index uint64) int {
+	if m.appliedAt(index) {
+		return 0
+	}
+
+	t := before.UnixNano()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var removed []string
+	for i := 0; i < m.store.len(); i++ {
+		if !m.store.removed(i) && m.store.stamp(i).expired(t) {
+			removed = append(removed, m.store.id(i))
+			m.store.remove(i, index)
+		}
+	}
+
+	if len(removed) > 0 {
+		m.forget(removed)
+		m.purge()
+	}
+
+	return len(removed)
+}
+
+// purge drops the removed columns of a store in memory once more than a
+// quarter of its columns were removed, and renumbers the shingles and
+// passages of the columns which are kept. The lock must be held.
+func (m *MinHasher) purge() {
+	s, ok := m.store.(*memStore)
+	if !ok || 4*s.dead() <= s.len() {
+		return
+	}
+
+	renumber := s.purge()
+	if renumber == nil {
+		return
+	}
+
+	if m.shingles != nil {
+		shingles := m.shingles[:0]
+		for i, hashes := range m.shingles {
+			if renumber[i] >= 0 {
+				shingles = append(shingles, hashes)
+			}
+		}
+		m.shingles = shingles
+	}
+
+	passages := m.passages[:0]
+	for _, p := range m.passages {
+		if c := renumber[p.column]; c >= 0 {
+			p.column = c
+			passages = append(passages, p)
+		}
+	}
+	m.passages = passages
+}
@@ -0,0 +1,186 @@
+package minhash
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinHasher_ExpireAt(t *testing.T) {
+	c := &Config{Bands: 20, Rows: 2, ShingleSize: 2, Passages: PassageParagraph, StoreShingles: true}
+	mh, err := NewFromConfig(c)
+	require.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	r := rand.New(rand.NewSource(7))
+	docs := make([]string, 6)
+	for i := range docs {
+		docs[i] = randomWords(r, 20)
+		hashed, err := mh.Hash(strings.NewReader(docs[i]))
+		require.NoError(t, err)
+
+		// the even documents expire after an hour, the odd never do
+		ttl := time.Duration(0)
+		if i%2 == 0 {
+			ttl = time.Hour
+		}
+
+		require.NoError(t, mh.AddHashedAt(strconv.Itoa(i), hashed, NewStamp(now, ttl), uint64(i+1)))
+	}
+
+	assert.False(t, mh.Expiring(now))
+	assert.True(t, mh.Expiring(now.Add(time.Hour)))
+
+	assert.Equal(t, 3, mh.ExpireAt(now.Add(time.Hour), 7))
+	assert.False(t, mh.Expiring(now.Add(time.Hour)))
+
+	// the entry is not applied again when the log is replayed
+	assert.Equal(t, 0, mh.ExpireAt(now.Add(time.Hour), 7))
+
+	stats := mh.Stats()
+	assert.Equal(t, 3, stats.Documents)
+	assert.Equal(t, 3, stats.IDs)
+
+	// the removed columns were purged, and the passages
+	// and shingles of those kept were renumbered
+	assert.Equal(t, 3, mh.store.len())
+
+	for i, doc := range docs {
+		assert.Equal(t, i%2 == 1, mh.Contains(strconv.Itoa(i)))
+
+		results, err := mh.FindSimilar(strings.NewReader(doc), 1)
+		require.NoError(t, err)
+
+		passages, err := mh.FindPassages(strings.NewReader(doc), 1)
+		require.NoError(t, err)
+
+		if i%2 == 0 {
+			assert.Len(t, results, 0)
+			assert.Len(t, passages, 0)
+			continue
+		}
+
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, strconv.Itoa(i), results[0].ID)
+		}
+
+		if assert.Len(t, passages, 1) {
+			assert.Equal(t, strconv.Itoa(i), passages[0].ID)
+		}
+
+		e, err := mh.Explain(strconv.Itoa(i), strings.NewReader(doc))
+		if assert.NoError(t, err) && assert.NotNil(t, e.ExactSimilarity) {
+			assert.Equal(t, 1.0, *e.ExactSimilarity)
+		}
+	}
+}
+
+func TestMinHasher_ExpireDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expire")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Config{Bands: 20, Rows: 2, ShingleSize: 2, Storage: StorageDisk}
+	mh, err := Open(c, dir)
+	require.NoError(t, err)
+
+	store := mh.store.(*diskStore)
+	store.flushSize = 4
+	store.maxSegments = 100
+
+	now := time.Unix(1000, 0)
+	r := rand.New(rand.NewSource(7))
+	docs := make([]string, 20)
+	for i := range docs {
+		docs[i] = randomWords(r, 20)
+		hashed, err := mh.Hash(strings.NewReader(docs[i]))
+		require.NoError(t, err)
+
+		// the first few documents expire
+		ttl := time.Duration(0)
+		if i < 4 {
+			ttl = time.Minute
+		}
+
+		require.NoError(t, mh.AddHashedAt(strconv.Itoa(i%10), hashed, NewStamp(now, ttl), uint64(i+1)))
+	}
+
+	require.NoError(t, mh.Checkpoint())
+
+	// few columns expire, so the segments are kept with tombstones
+	assert.Equal(t, 4, mh.ExpireAt(now.Add(time.Minute), 21))
+	require.NoError(t, mh.Checkpoint())
+
+	check := func(mh *MinHasher) {
+		stats := mh.Stats()
+		assert.Equal(t, 16, stats.Documents)
+		assert.Equal(t, 10, stats.IDs)
+
+		for i, doc := range docs {
+			results, err := mh.FindSimilar(strings.NewReader(doc), 1)
+			require.NoError(t, err)
+			if i < 4 {
+				assert.Len(t, results, 0, docs[i])
+			} else if assert.Len(t, results, 1, docs[i]) {
+				assert.Equal(t, strconv.Itoa(i%10), results[0].ID)
+			}
+		}
+	}
+
+	check(mh)
+	require.NoError(t, mh.Close())
+
+	// the tombstones are kept in the manifest
+	m, err := readManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 10, m.IDs)
+	assert.Len(t, m.Removed, 1)
+
+	mh, err = Open(c, dir)
+	require.NoError(t, err)
+	check(mh)
+
+	// the removed columns are dropped when the segments are compacted
+	store = mh.store.(*diskStore)
+	require.NoError(t, store.compact())
+	assert.Equal(t, 16, store.len())
+	assert.Equal(t, 0, store.dead())
+	check(mh)
+	require.NoError(t, mh.Close())
+
+	m, err = readManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 10, m.IDs)
+	assert.Len(t, m.Removed, 0)
+
+	mh, err = Open(c, dir)
+	require.NoError(t, err)
+	defer mh.Close()
+	check(mh)
+}
+
+func TestConfig_TTL(t *testing.T) {
+	c := &Config{Bands: 10, Rows: 2, ShingleSize: 2, TTL: "168h"}
+	if assert.NoError(t, c.Validate()) {
+		assert.Equal(t, 7*24*time.Hour, c.TimeToLive())
+	}
+
+	assert.Equal(t, time.Duration(0), (&Config{}).TimeToLive())
+
+	c.TTL = "a week"
+	assert.Error(t, c.Validate())
+
+	c.TTL = "-1h"
+	assert.Error(t, c.Validate())
+
+	c.TTL = "1h"
+	c.Algorithm = AlgorithmSimHash
+	assert.Error(t, c.Validate())
+}
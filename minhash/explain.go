@@ -0,0 +1,137 @@
+package minhash
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/mauidude/deduper/text"
+)
+
+// ErrDocumentNotFound is returned when a document is not in the index.
+var ErrDocumentNotFound = errors.New("document not found")
+
+// Explanation describes how the similarity of a query document to a stored
+// document was computed. It is meant for tuning the bands, rows and
+// normalizers of an index.
+type Explanation struct {
+	// ID is the ID of the stored document.
+	ID string `json:"id"`
+
+	// Hashes is the number of hashes in each signature.
+	Hashes int `json:"hashes"`
+
+	// AgreeingHashes is the number of positions at which
+	// the signatures of the documents are equal.
+	AgreeingHashes int `json:"agreeing_hashes"`
+
+	// Bands are the indexes of the bands which the documents share.
+	// The documents are only compared if they share a band.
+	Bands []int `json:"bands"`
+
+	// Similarity is the similarity estimated from the signatures.
+	Similarity float64 `json:"similarity"`
+
+	// QueryShingles is the number of distinct shingles of the query.
+	QueryShingles int `json:"query_shingles"`
+
+	// DocumentShingles is the number of distinct shingles of the
+	// stored document.
+	DocumentShingles int `json:"document_shingles"`
+
+	// SharedShingles are the shingles of the query which are also shingles
+	// of the stored document. It is only set if the index stores shingles.
+	SharedShingles []string `json:"shared_shingles,omitempty"`
+
+	// ExactSimilarity is the exact Jaccard similarity of the shingles of the
+	// documents. It is only set if the index stores shingles.
+	ExactSimilarity *float64 `json:"exact_similarity,omitempty"`
+}
+
+// Explain explains the similarity of the document read from r to the stored
+// document with the given id. ErrDocumentNotFound is returned if there is no
+// such document. If a document was added more than once, the latest is used.
+func (m *MinHasher) Explain(id string, r io.Reader) (*Explanation, error) {
+	texts := make(map[uint64]string)
+	col, size, err := m.hashShingles(r, func(h uint64, s *text.Shingler) {
+		texts[h] = s.Text()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bcol := m.bandColumn(col)
+	col = m.layout.truncate(col)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	i := m.store.last(id)
+	if i < 0 {
+		return nil, ErrDocumentNotFound
+	}
+
+	stored := m.layout.signature(m.store.packed(i))
+
+	e := &Explanation{
+		ID:               id,
+		Hashes:           len(col),
+		Bands:            make([]int, 0),
+		Similarity:       m.estimate(stored, col),
+		QueryShingles:    size,
+		DocumentShingles: m.store.size(i),
+	}
+
+	for j := range col {
+		if col[j] == stored[j] {
+			e.AgreeingHashes++
+		}
+	}
+
+	for j, b := range m.store.band(i) {
+		if b == bcol[j] {
+			e.Bands = append(e.Bands, j)
+		}
+	}
+
+	if m.shingles != nil {
+		hashes := m.shingles[i]
+
+		e.SharedShingles = make([]string, 0)
+		for h, shingle := range texts {
+			if containsShingle(hashes, h) {
+				e.SharedShingles = append(e.SharedShingles, shingle)
+			}
+		}
+		sort.Strings(e.SharedShingles)
+
+		shared := len(e.SharedShingles)
+		exact := float64(shared) / float64(size+len(hashes)-shared)
+		e.ExactSimilarity = &exact
+	}
+
+	return e, nil
+}
+
+// sortedShingles returns the shingle hashes of a set in increasing order.
+func sortedShingles(set map[uint64]struct{}) []uint64 {
+	hashes := make([]uint64, 0, len(set))
+	for h := range set {
+		hashes = append(hashes, h)
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i] < hashes[j]
+	})
+
+	return hashes
+}
+
+// containsShingle returns true if the sorted hashes contain h.
+func containsShingle(hashes []uint64, h uint64) bool {
+	i := sort.Search(len(hashes), func(i int) bool {
+		return hashes[i] >= h
+	})
+
+	return i < len(hashes) && hashes[i] == h
+}
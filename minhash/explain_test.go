@@ -0,0 +1,50 @@
+package minhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinHasher_Explain(t *testing.T) {
+	mh, err := NewFromConfig(&Config{Bands: 10, Rows: 2, ShingleSize: 2, StoreShingles: true})
+	require.NoError(t, err)
+
+	require.NoError(t, mh.Add("1", strings.NewReader("the quick brown fox jumps")))
+
+	e, err := mh.Explain("1", strings.NewReader("the quick brown dog jumps"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "1", e.ID)
+	assert.Equal(t, 20, e.Hashes)
+	assert.Equal(t, 4, e.QueryShingles)
+	assert.Equal(t, 4, e.DocumentShingles)
+	assert.Equal(t, []string{"quick brown", "the quick"}, e.SharedShingles)
+	if assert.NotNil(t, e.ExactSimilarity) {
+		assert.Equal(t, 2.0/6, *e.ExactSimilarity)
+	}
+
+	// the bands are those whose rows all agree
+	sig, err := mh.Signature(strings.NewReader("the quick brown dog jumps"))
+	require.NoError(t, err)
+	for _, b := range e.Bands {
+		assert.Equal(t, mh.layout.signature(mh.store.packed(0))[b*2:b*2+2], vector(sig[b*2:b*2+2]))
+	}
+	assert.True(t, e.AgreeingHashes >= 2*len(e.Bands))
+
+	_, err = mh.Explain("2", strings.NewReader("the quick brown dog jumps"))
+	assert.Equal(t, ErrDocumentNotFound, err)
+
+	// without stored shingles only the estimate is explained
+	mh = New(10, 2, 2)
+	require.NoError(t, mh.Add("1", strings.NewReader("the quick brown fox jumps")))
+
+	e, err = mh.Explain("1", strings.NewReader("the quick brown fox jumps"))
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, e.Similarity)
+	assert.Len(t, e.Bands, 10)
+	assert.Nil(t, e.SharedShingles)
+	assert.Nil(t, e.ExactSimilarity)
+}
@@ -0,0 +1,196 @@
+package minhash
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExportVersion is the version of the format written by Export.
+const ExportVersion = 1
+
+// the number of columns exported each time the lock is taken
+const exportBatchSize = 1000
+
+// ExportHeader is the first line of an export.
+type ExportHeader struct {
+	// Version is the version of the format.
+	Version int `json:"version"`
+
+	// Config is the config of the exported index.
+	Config Config `json:"config"`
+
+	// Documents is the number of documents which follow,
+	// or fewer if documents expire while it is written.
+	Documents int `json:"documents"`
+}
+
+// ExportRecord is a document of an export. Every line
+// after the header is a record.
+type ExportRecord struct {
+	// ID is the id of the document.
+	ID string `json:"id"`
+
+	// Document is the document as hashed by Hash, with
+	// its signature, size, passages and shingles.
+	Document json.RawMessage `json:"document"`
+
+	// Added is when the document was added, if it is known.
+	Added *time.Time `json:"added,omitempty"`
+
+	// Expires is when the document expires, if it ever does.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Stamp returns when the document was added and expires.
+func (r *ExportRecord) Stamp() Stamp {
+	var st Stamp
+	if r.Added != nil {
+		st.Added = *r.Added
+	}
+
+	if r.Expires != nil {
+		st.Expires = *r.Expires
+	}
+
+	return st
+}
+
+// Export writes the documents of the MinHasher to w as newline delimited
+// JSON: an ExportHeader followed by an ExportRecord for each document, in
+// the order they were added. The export holds the documents added before
+// it was called, but documents can be added while it is written. Documents
+// which expire while it is written are left out.
+func (m *MinHasher) Export(w io.Writer) error {
+	// the columns keep their numbers until the export is written
+	m.mutex.Lock()
+	n := m.store.len()
+	documents := n - m.store.dead()
+	m.store.hold()
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		m.store.release()
+		m.mutex.Unlock()
+	}()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	if err := enc.Encode(&ExportHeader{Version: ExportVersion, Config: m.config, Documents: documents}); err != nil {
+		return err
+	}
+
+	// columns never change once they are added, only their removal, so
+	// they are read in batches rather than holding the lock for the whole
+	// export
+	records := make([]ExportRecord, 0, exportBatchSize)
+	hashing := m.config.hashing()
+	p := 0
+	for start := 0; start < n; start += exportBatchSize {
+		end := start + exportBatchSize
+		if end > n {
+			end = n
+		}
+
+		records = records[:0]
+
+		m.mutex.RLock()
+		for i := start; i < end; i++ {
+			doc := &document{
+				Signature: m.layout.signature(m.store.packed(i)),
+				Hashing:   hashing,
+				Size:      m.store.size(i),
+			}
+
+			for ; p < len(m.passages) && m.passages[p].column == i; p++ {
+				doc.Passages = append(doc.Passages, m.passages[p])
+			}
+
+			if m.store.removed(i) {
+				continue
+			}
+
+			if m.config.StoreShingles {
+				doc.Shingles = m.shingles[i]
+			}
+
+			b, err := json.Marshal(doc)
+			if err != nil {
+				m.mutex.RUnlock()
+				return err
+			}
+
+			record := ExportRecord{ID: m.store.id(i), Document: b}
+
+			st := m.store.stamp(i).Stamp()
+			if !st.Added.IsZero() {
+				record.Added = &st.Added
+			}
+
+			if !st.Expires.IsZero() {
+				record.Expires = &st.Expires
+			}
+
+			records = append(records, record)
+		}
+		m.mutex.RUnlock()
+
+		for i := range records {
+			if err := enc.Encode(&records[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ExportReader reads an export written by Export.
+type ExportReader struct {
+	// Header is the header of the export.
+	Header ExportHeader
+
+	dec *json.Decoder
+}
+
+// NewExportReader reads the header of the export read from r. An error
+// is returned if the export was written in an unknown version.
+func NewExportReader(r io.Reader) (*ExportReader, error) {
+	e := &ExportReader{
+		dec: json.NewDecoder(r),
+	}
+
+	if err := e.dec.Decode(&e.Header); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("export has no header")
+		}
+
+		return nil, err
+	}
+
+	if e.Header.Version != ExportVersion {
+		return nil, fmt.Errorf("unknown export version %d", e.Header.Version)
+	}
+
+	return e, nil
+}
+
+// Next returns the next record of the export, or io.EOF
+// if there are no more records.
+func (e *ExportReader) Next() (*ExportRecord, error) {
+	record := &ExportRecord{}
+	if err := e.dec.Decode(record); err != nil {
+		return nil, err
+	}
+
+	if len(record.Document) == 0 {
+		return nil, fmt.Errorf("document %q has no hashes", record.ID)
+	}
+
+	return record, nil
+}
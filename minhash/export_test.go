@@ -0,0 +1,97 @@
+package minhash
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinHasher_Export(t *testing.T) {
+	c := &Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, Passages: PassageParagraph, StoreShingles: true}
+	mh, err := NewFromConfig(c)
+	require.NoError(t, err)
+
+	require.NoError(t, mh.Add("1", strings.NewReader("the quick brown fox\n\njumps over the lazy dog")))
+	require.NoError(t, mh.Add("2", strings.NewReader("an entirely different sentence")))
+
+	var b bytes.Buffer
+	require.NoError(t, mh.Export(&b))
+
+	r, err := NewExportReader(&b)
+	require.NoError(t, err)
+	assert.Equal(t, ExportVersion, r.Header.Version)
+	assert.Equal(t, 2, r.Header.Documents)
+	assert.NoError(t, c.Compatible(&r.Header.Config))
+
+	var ids []string
+	var hashed [][]byte
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		ids = append(ids, record.ID)
+		hashed = append(hashed, record.Document)
+	}
+
+	assert.Equal(t, []string{"1", "2"}, ids)
+
+	imported, err := NewFromConfig(c)
+	require.NoError(t, err)
+	require.NoError(t, imported.AddHashedBatchAt(ids, hashed, nil, 1))
+
+	results, err := imported.FindSimilar(strings.NewReader("the quick brown fox\n\njumps over the lazy dog"), 1)
+	require.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "1", results[0].ID)
+	}
+
+	passages, err := imported.FindPassages(strings.NewReader("jumps over the lazy dog"), 1)
+	require.NoError(t, err)
+	assert.Len(t, passages, 1)
+
+	e, err := imported.Explain("1", strings.NewReader("the quick brown fox"))
+	require.NoError(t, err)
+	assert.Len(t, e.SharedShingles, 3)
+
+	// the batch of an entry which was added before is skipped
+	require.NoError(t, imported.AddHashedBatchAt([]string{"3"}, hashed[:1], nil, 1))
+	assert.False(t, imported.Contains("3"))
+
+	// nothing is added if a document of the batch is invalid
+	assert.Error(t, imported.AddHashedBatchAt([]string{"3", "4"}, [][]byte{hashed[0], []byte("{}")}, nil, 2))
+	assert.False(t, imported.Contains("3"))
+}
+
+func TestConfig_Compatible(t *testing.T) {
+	c := &Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42}
+
+	assert.NoError(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, HashFamily: DefaultHashFamily, BBits: 32}))
+	assert.NoError(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, Normalizers: []string{}, Tokenizer: "whitespace"}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 43}))
+	assert.Error(t, c.Compatible(&Config{Bands: 20, Rows: 2, ShingleSize: 2, Seed: 42}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 1, ShingleSize: 2, Seed: 42}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, HashFamily: "oph"}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, BBits: 8}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, Algorithm: AlgorithmSimHash}))
+
+	// documents split into other shingles cannot be compared either
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 3, Seed: 42}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, Normalizers: []string{"lowercase"}}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, Tokenizer: "unicode"}))
+	assert.Error(t, c.Compatible(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 42, StopWords: "english"}))
+}
+
+func TestNewExportReader(t *testing.T) {
+	_, err := NewExportReader(strings.NewReader(""))
+	assert.Error(t, err)
+
+	_, err = NewExportReader(strings.NewReader(`{"version": 2}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,64 @@
+package minhash
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// DefaultHashFamily is the hash family used when none is configured.
+const DefaultHashFamily = "universal32"
+
+// HashFamily is a family of hash functions used to compute the
+// signatures of documents.
+type HashFamily interface {
+	// New returns a sketch for computing the signature of a document.
+	New() Sketch
+
+	// Similarity estimates the Jaccard similarity of the documents
+	// with the given signatures.
+	Similarity(a, b vector) float64
+}
+
+// Sketch accumulates the shingles of a single document.
+type Sketch interface {
+	// Add adds the hash of a shingle of the document.
+	Add(shingle uint64)
+
+	// Signature returns the signature of the shingles added so far.
+	Signature() vector
+}
+
+// newHashFamily creates the hash family with the given name
+// which produces signatures of size n.
+func newHashFamily(name string, n int, r *rand.Rand) (HashFamily, error) {
+	switch name {
+	case "", "universal32":
+		return newUniversal32(n, r), nil
+	case "universal64":
+		return newUniversal64(n, r), nil
+	case "oph":
+		return newOnePermutation(n, r), nil
+	case "icws":
+		return newICWS(n, r), nil
+	}
+
+	return nil, fmt.Errorf("unknown hash family %q", name)
+}
+
+// agreement returns the fraction of positions at which two signatures
+// are equal, which is an unbiased estimate of the Jaccard similarity
+// of the documents.
+func agreement(a, b vector) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+
+	return float64(equal) / float64(len(a))
+}
@@ -0,0 +1,82 @@
+package minhash
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashFamilies_Similarity(t *testing.T) {
+	// two sets of 1000 shingles sharing 600, a Jaccard similarity of 600/1400
+	var a, b []uint64
+	for i := 0; i < 1400; i++ {
+		s := Mix64(uint64(i))
+		if i < 1000 {
+			a = append(a, s)
+		}
+		if i >= 400 {
+			b = append(b, s)
+		}
+	}
+
+	expected := 600.0 / 1400.0
+
+	for _, name := range []string{"universal64", "oph"} {
+		family, err := newHashFamily(name, 512, rand.New(rand.NewSource(31)))
+		require.NoError(t, err)
+
+		sa, sb := family.New(), family.New()
+		for _, s := range a {
+			sa.Add(s)
+		}
+		for _, s := range b {
+			sb.Add(s)
+		}
+
+		assert.InDelta(t, expected, family.Similarity(sa.Signature(), sb.Signature()), 0.07, name)
+		assert.Equal(t, 1.0, family.Similarity(sa.Signature(), sa.Signature()), name)
+	}
+
+	_, err := newHashFamily("bogus", 10, rand.New(rand.NewSource(31)))
+	assert.Error(t, err)
+}
+
+func TestOnePermutation_Densify(t *testing.T) {
+	o := newOnePermutation(64, rand.New(rand.NewSource(31)))
+
+	s := o.New()
+	s.Add(42)
+
+	sig := s.Signature()
+	for _, v := range sig {
+		assert.NotEqual(t, uint32(math.MaxUint32), v)
+		assert.Equal(t, sig[0], v)
+	}
+}
+
+func TestMulAddMod61(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	p := new(big.Int).SetUint64(mersenne61)
+
+	for i := 0; i < 1000; i++ {
+		a := uint64(r.Int63n(int64(mersenne61)))
+		x := uint64(r.Int63n(int64(mersenne61)))
+		b := uint64(r.Int63n(int64(mersenne61)))
+
+		expected := new(big.Int).SetUint64(a)
+		expected.Mul(expected, new(big.Int).SetUint64(x))
+		expected.Add(expected, new(big.Int).SetUint64(b))
+		expected.Mod(expected, p)
+
+		assert.Equal(t, expected.Uint64(), mulAddMod61(a, x, b))
+	}
+}
+
+func TestAgreement(t *testing.T) {
+	assert.Equal(t, 0.5, agreement(vector{1, 2, 3, 4}, vector{1, 2, 4, 3}))
+	assert.Equal(t, 0.0, agreement(vector{1, 2}, vector{1}))
+}
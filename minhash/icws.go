@@ -0,0 +1,89 @@
+package minhash
+
+import (
+	"math"
+	"math/rand"
+)
+
+// icws implements Improved Consistent Weighted Sampling (Ioffe, 2010),
+// a weighted MinHash. Each shingle is weighted by the number of times it
+// appears in the document, and two signatures agree at a position with
+// probability equal to the weighted Jaccard similarity of the documents,
+// sum(min(a_i, b_i)) / sum(max(a_i, b_i)).
+type icws struct {
+	// a salt for each hash function
+	salts []uint64
+}
+
+func newICWS(n int, r *rand.Rand) *icws {
+	w := &icws{
+		salts: make([]uint64, n),
+	}
+
+	for i := range w.salts {
+		w.salts[i] = uint64(r.Int63())
+	}
+
+	return w
+}
+
+func (w *icws) New() Sketch {
+	return &icwsSketch{
+		w:      w,
+		counts: make(map[uint64]float64),
+	}
+}
+
+func (w *icws) Similarity(a, b vector) float64 {
+	return agreement(a, b)
+}
+
+type icwsSketch struct {
+	w      *icws
+	counts map[uint64]float64
+}
+
+func (s *icwsSketch) Add(shingle uint64) {
+	s.counts[shingle]++
+}
+
+func (s *icwsSketch) Signature() vector {
+	sig := make(vector, len(s.w.salts))
+
+	for i, salt := range s.w.salts {
+		best := math.Inf(1)
+		var bestShingle uint64
+		var bestT float64
+
+		for shingle, weight := range s.counts {
+			// the random variables are derived from the shingle and
+			// hash function so every document samples them the same way
+			h := Mix64(shingle ^ salt)
+			r := -math.Log(unit(h+1) * unit(h+2))
+			c := -math.Log(unit(h+3) * unit(h+4))
+			beta := unit(h + 5)
+
+			t := math.Floor(math.Log(weight)/r + beta)
+
+			// ln(a) where a = c / (y * e^r) and y = e^(r(t - beta))
+			lnA := math.Log(c) - r*(t-beta+1)
+
+			// ties are broken by shingle so map order does not matter
+			if lnA < best || lnA == best && shingle < bestShingle {
+				best = lnA
+				bestShingle = shingle
+				bestT = t
+			}
+		}
+
+		// the sample is the shingle and its quantized weight
+		sig[i] = uint32(Mix64(bestShingle ^ Mix64(uint64(int64(bestT))^salt)))
+	}
+
+	return sig
+}
+
+// unit maps a hash to a uniformly distributed float in (0, 1).
+func unit(h uint64) float64 {
+	return (float64(Mix64(h)>>11) + 0.5) / (1 << 53)
+}
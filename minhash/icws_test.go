@@ -0,0 +1,78 @@
+package minhash
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// weightedJaccard returns the exact weighted Jaccard similarity of two multisets.
+func weightedJaccard(a, b map[uint64]float64) float64 {
+	var min, max float64
+	for k, va := range a {
+		vb := b[k]
+		min += math.Min(va, vb)
+		max += math.Max(va, vb)
+	}
+
+	for k, vb := range b {
+		if _, ok := a[k]; !ok {
+			max += vb
+		}
+	}
+
+	return min / max
+}
+
+func TestICWS_WeightedJaccard(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	w := newICWS(1024, rand.New(rand.NewSource(31)))
+
+	for i := 0; i < 5; i++ {
+		a := make(map[uint64]float64)
+		b := make(map[uint64]float64)
+
+		// shingles with random counts, some only in one of the documents
+		for s := uint64(0); s < 60; s++ {
+			if r.Intn(4) > 0 {
+				a[s] = float64(1 + r.Intn(50))
+			}
+			if r.Intn(4) > 0 {
+				b[s] = float64(1 + r.Intn(50))
+			}
+		}
+
+		sa, sb := w.New(), w.New()
+		for s, n := range a {
+			for j := 0; j < int(n); j++ {
+				sa.Add(s)
+			}
+		}
+		for s, n := range b {
+			for j := 0; j < int(n); j++ {
+				sb.Add(s)
+			}
+		}
+
+		expected := weightedJaccard(a, b)
+		assert.InDelta(t, expected, w.Similarity(sa.Signature(), sb.Signature()), 0.05)
+	}
+}
+
+func TestICWS_Counts(t *testing.T) {
+	w := newICWS(256, rand.New(rand.NewSource(31)))
+
+	// the same shingles with different counts are not identical
+	a, b := w.New(), w.New()
+	for i := 0; i < 10; i++ {
+		a.Add(1)
+		a.Add(2)
+	}
+	b.Add(1)
+	b.Add(2)
+
+	assert.Equal(t, 1.0, w.Similarity(a.Signature(), a.Signature()))
+	assert.InDelta(t, 0.1, w.Similarity(a.Signature(), b.Signature()), 0.06)
+}
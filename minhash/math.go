@@ -6,7 +6,7 @@ import (
 	"encoding/binary"
 )
 
-// vector represents a column of a matrix
+// vector represents a column of the signature matrix
 type vector []uint32
 
 // signature returns a base64 encoded string representation of the vector.
@@ -18,6 +18,3 @@ func (v vector) signature() string {
 
 	return base64.URLEncoding.EncodeToString(buf.Bytes())
 }
-
-// matrix is a two-dimensional collection of values.
-type matrix []vector
@@ -1,11 +1,18 @@
 package minhash
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
 
-	mapset "github.com/deckarep/golang-set"
 	"github.com/mauidude/deduper/text"
 )
 
@@ -17,7 +24,14 @@ const (
 	p2 = uint64(7562380294967317)
 )
 
-type hasher func(...uint32) uint32
+var (
+	// ErrEmptyDocument is returned when a document has no tokens.
+	ErrEmptyDocument = errors.New("document has no text")
+
+	// ErrDocumentTooShort is returned when a document has fewer tokens than
+	// the shingle size and short documents are rejected.
+	ErrDocumentTooShort = errors.New("document has fewer words than the shingle size")
+)
 
 // Match represents a matching document.
 type Match struct {
@@ -30,49 +44,169 @@ type Match struct {
 	Similarity float64 `json:"similarity"`
 }
 
+// Mode is how the similarity of two documents is measured.
+type Mode int
+
+const (
+	// ModeJaccard is the Jaccard similarity of the shingles of the documents.
+	ModeJaccard Mode = iota
+
+	// ModeContainment is the fraction of the shingles of a stored
+	// document which are also in the query document.
+	ModeContainment
+
+	// ModeContained is the fraction of the shingles of the query
+	// document which are also in a stored document.
+	ModeContained
+)
+
+// Window limits a query to the documents added from Since, inclusive,
+// until Until, exclusive. Either bound is unlimited if it is the zero
+// time. Documents whose time of addition is unknown are only found by
+// unlimited windows.
+type Window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// window is a Window as it is compared with the stored
+// times, in Unix nanoseconds, zero for unlimited bounds.
+type window struct {
+	since int64
+	until int64
+}
+
+func (w Window) window() window {
+	return window{since: unixNano(w.Since), until: unixNano(w.Until)}
+}
+
+// contains returns true if a document added at the given time is in the window.
+func (w window) contains(added int64) bool {
+	if w.since == 0 && w.until == 0 {
+		return true
+	}
+
+	return added != 0 && added >= w.since && (w.until == 0 || added < w.until)
+}
+
 // New creates a new MinHasher with the given band size, number of rows, and shingle size.
+// The text is not normalized before shingling.
 func New(b int, r int, shingleSize int) *MinHasher {
+	mh, err := NewFromConfig(&Config{
+		Bands:       b,
+		Rows:        r,
+		ShingleSize: shingleSize,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return mh
+}
+
+// NewFromConfig creates a new MinHasher from the given config.
+func NewFromConfig(c *Config) (*MinHasher, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	analyzer, err := text.NewAnalyzer(c.Normalizers, c.Tokenizer, c.StopWords)
+	if err != nil {
+		return nil, err
+	}
+	analyzer.AllowShort = c.ShortDocuments != ShortReject
+
+	family, err := newHashFamily(c.HashFamily, c.Bands*c.Rows, rand.New(rand.NewSource(c.HashSeed())))
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLayout(c.Bands*c.Rows, c.BBits, c.Bands)
+
+	var matched map[string]int64
+	if c.MaxDocuments > 0 && c.Eviction == EvictLRU {
+		matched = make(map[string]int64)
+	}
+
 	return &MinHasher{
-		hashers:       generateHahsers(b*r, p1),
-		bandHashers:   generateHahsers(b, p2),
-		matrix:        make(matrix, 0),
-		r:             r,
-		b:             b,
-		n:             shingleSize,
-		columnMapping: make(map[int]string),
-		ids:           mapset.NewSet(),
+		config:      *c,
+		family:      family,
+		bandHashers: generateHashers(c.Bands, p2, rand.New(rand.NewSource(c.HashSeed()))),
+		layout:      l,
+		store:       newMemStore(l),
+		r:           c.Rows,
+		b:           c.Bands,
+		n:           c.ShingleSize,
+		analyzer:    analyzer,
+		matched:     matched,
+	}, nil
+}
+
+// Open creates a new MinHasher from the given config. If the config uses
+// StorageDisk, its documents are stored in dir and the documents stored
+// there before are opened. Otherwise the documents of the last Checkpoint
+// to dir, if any, are loaded into memory.
+func Open(c *Config, dir string) (*MinHasher, error) {
+	m, err := NewFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dir = dir
+
+	if c.Storage == StorageDisk {
+		m.store, err = openDiskStore(dir, m.layout, &m.mutex)
+	} else {
+		m.store, err = loadCheckpoint(dir, m.layout)
+	}
+
+	if err != nil {
+		return nil, err
 	}
+
+	return m, nil
 }
 
 // MinHasher provides near-similar matching capabilities on large
 // strings of text.
 type MinHasher struct {
-	// The mapping of column indexes in the matrix to document ids.
-	columnMapping map[int]string
+	// The config the MinHasher was created with.
+	config Config
 
-	// The hash functions used to hash the document's shingles.
-	hashers []hasher
+	// The hash functions used to compute the signatures of documents.
+	family HashFamily
 
 	// The hash functions used to hash the hash function results
 	// into bands.
-	bandHashers []hasher
+	bandHashers *hashers
+
+	// The signatures, bands and ids of the documents. Each column
+	// is the list of hash values of a document's shingles, eg element
+	// m[i,j] is the value of h[i](document[j]).
+	store columnStore
+
+	// The shape of the signatures in the store.
+	layout *layout
 
-	// The matrix of documents and hash values. Each vector
-	// is a list of hash values for a document's shingles, eg element m[i,j] is the
-	// value of h[i](document[j]).
-	matrix matrix
+	// The directory the MinHasher was opened from, if any.
+	dir string
 
-	// The unique list of document ids being stored.
-	ids mapset.Set
+	// The sorted shingle hashes of each column, if they are stored.
+	shingles [][]uint64
 
-	// The band matrix generated with LSH.
-	bands matrix
+	// The passages of the columns, if they are stored.
+	passages []passage
 
-	// Locks the bands matrix.
-	bandMutex sync.RWMutex
+	// Locks the store, shingles and passages.
+	mutex sync.RWMutex
 
-	// Locks the matrix.
-	matrixMutex sync.RWMutex
+	// The time each id was last matched, in Unix nanoseconds, if documents
+	// are evicted by EvictLRU. Matches are only known to the server which
+	// found them.
+	matched map[string]int64
+
+	// Locks matched, which is written while the store is read.
+	matchMutex sync.Mutex
 
 	// Number of bands.
 	b int
@@ -82,133 +216,604 @@ type MinHasher struct {
 
 	// N-shingles being used.
 	n int
+
+	// Splits documents into shingles.
+	analyzer *text.Analyzer
 }
 
 // Add adds a new document with the given ID to the collection of
-// documents.
-func (m *MinHasher) Add(id string, r io.Reader) {
-	column := m.hashColumn(r)
+// documents. An error is returned, and nothing is added, if the document
+// cannot be read or has too little text to be hashed.
+func (m *MinHasher) Add(id string, r io.Reader) error {
+	return m.AddAt(id, r, 0)
+}
+
+// AddAt is Add for the document of the raft log entry with the given index.
+// The document is skipped if the entry was added before, which happens when
+// the log is replayed over a store which persists its documents.
+func (m *MinHasher) AddAt(id string, r io.Reader, index uint64) error {
+	if m.appliedAt(index) {
+		return nil
+	}
+
+	doc, err := m.hashDocument(r)
+	if err != nil {
+		return err
+	}
+
+	m.add(id, doc, stamp{}, index)
+	return nil
+}
+
+// Hash hashes the document read from r without adding it. The result can be
+// added to any MinHasher created from the same config with AddHashed, so the
+// document can be replicated without its text.
+func (m *MinHasher) Hash(r io.Reader) ([]byte, error) {
+	doc, err := m.hashDocument(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+// AddHashed adds a document hashed by Hash with the given ID to the
+// collection of documents. An error is returned, and nothing is added,
+// if the hashed document is invalid.
+func (m *MinHasher) AddHashed(id string, hashed []byte) error {
+	return m.AddHashedAt(id, hashed, Stamp{}, 0)
+}
+
+// AddHashedAt is AddHashed for the document of the raft log entry with
+// the given index, like AddAt. The stamp is when the leader added the
+// document and when it expires.
+func (m *MinHasher) AddHashedAt(id string, hashed []byte, st Stamp, index uint64) error {
+	if m.appliedAt(index) {
+		return nil
+	}
+
+	doc, err := m.decodeDocument(hashed)
+	if err != nil {
+		return err
+	}
+
+	m.add(id, doc, st.stamp(), index)
+	return nil
+}
+
+// AddHashedBatchAt adds several documents hashed by Hash as the raft log
+// entry with the given index, with the stamp of each document if stamps
+// is not nil. Nothing is added if any of the documents is invalid, and
+// the documents are skipped if the entry was added before.
+func (m *MinHasher) AddHashedBatchAt(ids []string, hashed [][]byte, stamps []Stamp, index uint64) error {
+	if len(ids) != len(hashed) {
+		return fmt.Errorf("%d ids for %d documents", len(ids), len(hashed))
+	}
+
+	if stamps != nil && len(stamps) != len(ids) {
+		return fmt.Errorf("%d stamps for %d documents", len(stamps), len(ids))
+	}
+
+	if m.appliedAt(index) {
+		return nil
+	}
+
+	docs := make([]*document, len(hashed))
+	for i, b := range hashed {
+		var err error
+		if docs[i], err = m.decodeDocument(b); err != nil {
+			return fmt.Errorf("document %q: %v", ids[i], err)
+		}
+	}
+
+	for i, doc := range docs {
+		var st stamp
+		if stamps != nil {
+			st = stamps[i].stamp()
+		}
+
+		m.add(ids[i], doc, st, index)
+	}
+
+	return nil
+}
+
+// decodeDocument decodes and validates a document hashed by Hash.
+func (m *MinHasher) decodeDocument(hashed []byte) (*document, error) {
+	doc := &document{}
+	if err := json.Unmarshal(hashed, doc); err != nil {
+		return nil, err
+	}
+
+	// documents hashed before the fingerprint was added have none
+	if doc.Hashing != "" && doc.Hashing != m.config.hashing() {
+		return nil, errors.New("document was hashed with a different seed, hash family or b bits")
+	}
+
+	if len(doc.Signature) != m.b*m.r {
+		return nil, fmt.Errorf("signature has %d hashes, expected %d", len(doc.Signature), m.b*m.r)
+	}
+
+	for i, p := range doc.Passages {
+		if len(p.Signature) != m.b*m.r {
+			return nil, fmt.Errorf("passage signature has %d hashes, expected %d", len(p.Signature), m.b*m.r)
+		}
+
+		doc.Passages[i].bands = m.bandColumn(p.Signature)
+	}
+
+	return doc, nil
+}
+
+// appliedAt returns true if the raft log entry with the given index was
+// added before. Documents which were not added through the log have index 0.
+func (m *MinHasher) appliedAt(index uint64) bool {
+	if index == 0 {
+		return false
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return index <= m.store.applied()
+}
+
+// document is a hashed document. It is encoded as JSON to
+// replicate the document without its text.
+type document struct {
+	Signature vector `json:"signature"`
+
+	// the fingerprint of the config which hashed the document
+	Hashing string `json:"hashing,omitempty"`
+
+	// the number of distinct shingles
+	Size int `json:"size"`
+
+	// the passages of the document, if they are stored
+	Passages []passage `json:"passages,omitempty"`
+
+	// the sorted shingle hashes of the document, if they are stored
+	Shingles []uint64 `json:"shingles,omitempty"`
+}
+
+// hashDocument hashes the document read from r, and its
+// passages and shingles if the index stores them.
+func (m *MinHasher) hashDocument(r io.Reader) (*document, error) {
+	var body []byte
+	if m.config.Passages != "" {
+		// the body is hashed again, passage by passage
+		var err error
+		if body, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+
+		r = bytes.NewReader(body)
+	}
+
+	var visit func(uint64, *text.Shingler)
+	var shingles map[uint64]struct{}
+	if m.config.StoreShingles {
+		shingles = make(map[uint64]struct{})
+		visit = func(h uint64, _ *text.Shingler) {
+			shingles[h] = struct{}{}
+		}
+	}
+
+	col, size, err := m.hashShingles(r, visit)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &document{
+		Signature: col,
+		Hashing:   m.config.hashing(),
+		Size:      size,
+	}
+
+	if shingles != nil {
+		doc.Shingles = sortedShingles(shingles)
+	}
+
+	if body != nil {
+		if doc.Passages, err = m.hashPassages(body); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// add adds a hashed document to the store.
+func (m *MinHasher) add(id string, doc *document, st stamp, index uint64) {
+	bands := m.bandColumn(doc.Signature)
 
-	m.matrixMutex.Lock()
-	m.matrix = append(m.matrix, column)
-	m.columnMapping[len(m.matrix)-1] = id
-	m.matrixMutex.Unlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	m.ids.Add(id)
+	column := m.store.len()
+	m.store.add(id, doc.Signature, bands, doc.Size, st, index)
 
-	m.bandMutex.Lock()
-	m.bands = nil
-	m.bandMutex.Unlock()
+	if m.config.StoreShingles {
+		m.shingles = append(m.shingles, doc.Shingles)
+	}
+
+	for _, p := range doc.Passages {
+		p.column = column
+		m.passages = append(m.passages, p)
+	}
 }
 
 // FindSimilar returns a list of documents whose similarity to the given document
-// is greater than or equal to the threshold provided.
-func (m *MinHasher) FindSimilar(r io.Reader, threshold float64) []Match {
-	col := m.hashColumn(r)
-	col = m.bandColumn(col)
+// is greater than or equal to the threshold provided. An error is returned if
+// the document cannot be read or has too little text to be hashed.
+func (m *MinHasher) FindSimilar(r io.Reader, threshold float64) ([]Match, error) {
+	return m.Find(r, threshold, ModeJaccard)
+}
+
+// Find returns a list of documents whose similarity to the given document,
+// measured by mode, is greater than or equal to the threshold provided. An
+// error is returned if the document cannot be read or has too little text
+// to be hashed.
+//
+// Containment is estimated from the Jaccard similarity and the number of
+// shingles of each document. Documents much smaller or larger than the query
+// rarely share a band with it, so documents are partitioned by size, as in
+// LSH Ensemble (Zhu et al., 2016). The partitions whose matches may fall
+// below the threshold of the bands are compared with the query directly,
+// and the others are searched through the bands.
+func (m *MinHasher) Find(r io.Reader, threshold float64, mode Mode) ([]Match, error) {
+	return m.FindIn(r, threshold, mode, Window{})
+}
+
+// FindIn is Find for the documents added in the given window. Documents
+// outside the window are skipped as the candidates are scanned, before
+// their similarity is estimated.
+func (m *MinHasher) FindIn(r io.Reader, threshold float64, mode Mode, w Window) ([]Match, error) {
+	col, size, err := m.hashColumn(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bcol := m.bandColumn(col)
+	col = m.layout.truncate(col)
+	in := w.window()
 
 	similar := make([]Match, 0)
 
-	m.bandMutex.RLock()
-	if m.bands == nil {
-		m.bandMutex.RUnlock()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if mode == ModeJaccard {
+		// only documents which share a band with the input are compared
+		for _, i := range m.store.candidates(bcol) {
+			if !in.contains(m.store.stamp(i).added) {
+				continue
+			}
+
+			if sim := m.similarity(mode, col, size, i); sim >= threshold {
+				similar = append(similar, Match{
+					ID:         m.store.id(i),
+					Similarity: sim,
+				})
+			}
+		}
+
+		m.touch(similar)
+		return similar, nil
+	}
+
+	// the partitions of sizes which are compared directly, because
+	// their matches may not share a band with the query
+	var scan [63]bool
+	scanned := false
+	for p := range scan {
+		scan[p] = m.canContainPartition(mode, size, p, threshold) && m.minJaccard(mode, size, p, threshold) < m.bandThreshold()
+		scanned = scanned || scan[p]
+	}
+
+	// the other partitions are searched through the bands
+	var columns []int
+	for _, i := range m.store.candidates(bcol) {
+		if !scan[partition(m.store.size(i))] {
+			columns = append(columns, i)
+		}
+	}
+
+	if scanned {
+		for i := 0; i < m.store.len(); i++ {
+			if !m.store.removed(i) && scan[partition(m.store.size(i))] {
+				columns = append(columns, i)
+			}
+		}
+
+		sort.Ints(columns)
+	}
 
-		// lock as writer
-		m.bandMutex.Lock()
-		m.bands = m.bandMatrix()
-		m.bandMutex.Unlock()
+	for _, i := range columns {
+		if !in.contains(m.store.stamp(i).added) || !m.canContain(mode, size, m.store.size(i), threshold) {
+			continue
+		}
 
-		// relock as reader
-		m.bandMutex.RLock()
+		if sim := m.similarity(mode, col, size, i); sim >= threshold {
+			similar = append(similar, Match{
+				ID:         m.store.id(i),
+				Similarity: sim,
+			})
+		}
 	}
 
-	// for each document in the band matrix
-	for i, c := range m.bands {
-		// see if they share any common bands with input
-		for j := 0; j < len(col); j++ {
-			if col[j] == c[j] {
-				// needs deeper inspection ie jaccard similarity
-				sim := jaccard(c, col)
+	m.touch(similar)
+	return similar, nil
+}
+
+// FindPassages returns the documents with passages whose similarity to a
+// passage of the given document is greater than or equal to the threshold
+// provided, along with the offsets of the similar passages. ErrPassagesDisabled
+// is returned if the index does not store passages.
+func (m *MinHasher) FindPassages(r io.Reader, threshold float64) ([]PassageMatch, error) {
+	if m.config.Passages == "" {
+		return nil, ErrPassagesDisabled
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	queries, err := m.hashPassages(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(queries) == 0 {
+		return nil, ErrEmptyDocument
+	}
+
+	matches := make([]PassageMatch, 0)
+	byColumn := make(map[int]int)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, p := range m.passages {
+		if m.store.removed(p.column) {
+			continue
+		}
+
+		for _, q := range queries {
+			if !shareBand(p.bands, q.bands) {
+				continue
+			}
 
-				if sim >= threshold {
-					similar = append(similar, Match{
-						ID:         m.columnMapping[i],
-						Similarity: sim,
-					})
-				}
+			sim := m.family.Similarity(p.Signature, q.Signature)
+			if sim < threshold {
+				continue
+			}
 
-				break
+			i, ok := byColumn[p.column]
+			if !ok {
+				i = len(matches)
+				byColumn[p.column] = i
+				matches = append(matches, PassageMatch{ID: m.store.id(p.column)})
 			}
+
+			matches[i].Passages = append(matches[i].Passages, PassagePair{
+				QueryStart:    q.Start,
+				QueryEnd:      q.End,
+				DocumentStart: p.Start,
+				DocumentEnd:   p.End,
+				Similarity:    sim,
+			})
 		}
 	}
 
-	m.bandMutex.RUnlock()
+	return matches, nil
+}
+
+// Signature returns the signature of the document read from r. MinHashers
+// created from the same config return the same signature for a document,
+// so signatures can be computed outside of the server.
+func (m *MinHasher) Signature(r io.Reader) ([]uint32, error) {
+	col, _, err := m.hashColumn(r)
+	return col, err
+}
+
+// Config returns the config the MinHasher was created with.
+func (m *MinHasher) Config() Config {
+	return m.config
+}
 
-	return similar
+// Close flushes the documents of the MinHasher to disk, if it stores
+// them there, and releases its resources. It must not be used afterwards.
+func (m *MinHasher) Close() error {
+	return m.store.close()
 }
 
 // Contains returns true if the MinHasher contains
 // the document with the given id.
 func (m *MinHasher) Contains(id string) bool {
-	return m.ids.Contains(id)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.store.last(id) >= 0
 }
 
-func (m *MinHasher) hashColumn(r io.Reader) vector {
-	// the result which holds each minimum hash
-	// value of h_i at the ith index of each n-gram
-	column := make(vector, len(m.hashers))
+// hashColumn returns the signature of the document read from r
+// and its number of distinct shingles.
+func (m *MinHasher) hashColumn(r io.Reader) (vector, int, error) {
+	return m.hashShingles(r, nil)
+}
 
-	shingler := text.NewShingler(r, m.n)
+// hashShingles is hashColumn which also calls visit, if it is
+// not nil, with the hash of each distinct shingle.
+func (m *MinHasher) hashShingles(r io.Reader, visit func(uint64, *text.Shingler)) (vector, int, error) {
+	sketch := m.family.New()
 
-	// initialize to max value to find the min
-	for i, _ := range m.hashers {
-		column[i] = uint32(math.MaxUint32)
-	}
+	shingler := m.analyzer.Shingler(r, m.n)
 
+	shingles := make(map[uint64]struct{})
 	for shingler.Scan() {
-		sh := shingler.Text()
+		h := shingler.Hash()
+		if _, ok := shingles[h]; !ok && visit != nil {
+			visit(h, shingler)
+		}
 
-		// convert the string to a number by
-		// hashing it... similar to GetHashCode
-		// in C#
-		v := hashCode(sh)
+		shingles[h] = struct{}{}
+		sketch.Add(h)
+	}
 
-		for i, h := range m.hashers {
-			hash := h(v)
-			if hash < column[i] {
-				column[i] = hash
-			}
+	if err := shingler.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// a column without shingles would match every other such column
+	if shingler.Tokens() == 0 {
+		return nil, 0, ErrEmptyDocument
+	}
+
+	if len(shingles) == 0 {
+		return nil, 0, ErrDocumentTooShort
+	}
+
+	return sketch.Signature(), len(shingles), nil
+}
+
+// hashPassages splits a document into passages and hashes each of them.
+// Passages with too little text to be hashed are skipped.
+func (m *MinHasher) hashPassages(body []byte) ([]passage, error) {
+	var passages []passage
+	split := m.splitPassages(body)
+	runes := runeOffsets(body, split)
+
+	for i, offsets := range split {
+		col, _, err := m.hashColumn(bytes.NewReader(body[offsets[0]:offsets[1]]))
+		switch err {
+		case nil:
+		case ErrEmptyDocument, ErrDocumentTooShort:
+			continue
+		default:
+			return nil, err
 		}
+
+		passages = append(passages, passage{
+			Start:     runes[i][0],
+			End:       runes[i][1],
+			Signature: col,
+			bands:     m.bandColumn(col),
+		})
 	}
 
-	return column
+	return passages, nil
 }
 
-func (m *MinHasher) bandColumn(col vector) vector {
-	bcol := make(vector, m.b)
+// estimate estimates the Jaccard similarity of two signatures
+// with the bits kept by the store.
+func (m *MinHasher) estimate(a, b vector) float64 {
+	if m.layout.bits < 32 {
+		return bbitSimilarity(agreement(a, b), m.layout.bits)
+	}
+
+	return m.family.Similarity(a, b)
+}
 
-	for i, hash := range m.bandHashers {
-		for j := 0; j < len(col); j += m.r {
-			rows := col[j : j+m.r]
-			h := hash(rows...)
+// similarity returns the similarity, measured by mode, of the query
+// document with the given signature and size to the ith document.
+func (m *MinHasher) similarity(mode Mode, col vector, size int, i int) float64 {
+	j := m.estimate(m.layout.signature(m.store.packed(i)), col)
+	if mode == ModeJaccard {
+		return j
+	}
+
+	// |A n B| = J(|A| + |B|) / (1 + J)
+	intersection := j * float64(size+m.store.size(i)) / (1 + j)
+
+	var c float64
+	if mode == ModeContainment {
+		c = intersection / float64(m.store.size(i))
+	} else {
+		c = intersection / float64(size)
+	}
+
+	return math.Min(c, 1)
+}
+
+// canContain returns false if a document of size x cannot reach the
+// threshold against a query of size q because of their sizes alone.
+func (m *MinHasher) canContain(mode Mode, q int, x int, threshold float64) bool {
+	if mode == ModeContainment {
+		// at most q of the document's shingles are in the query
+		return threshold*float64(x) <= float64(q)
+	}
+
+	return threshold*float64(q) <= float64(x)
+}
+
+// canContainPartition returns true if any document in partition p
+// can reach the threshold with a query of size q.
+func (m *MinHasher) canContainPartition(mode Mode, q int, p int, threshold float64) bool {
+	if mode == ModeContainment {
+		// the smallest documents are the most likely to be contained
+		return m.canContain(mode, q, int(1)<<uint(p), threshold)
+	}
+
+	return m.canContain(mode, q, int(1)<<uint(p+1)-1, threshold)
+}
 
-			bcol[i] = h
+// minJaccard returns the lowest Jaccard similarity a document in partition
+// p can have with a query of size q and still reach the threshold.
+func (m *MinHasher) minJaccard(mode Mode, q int, p int, threshold float64) float64 {
+	// the bounds of the sizes in the partition
+	lo, hi := float64(int(1)<<uint(p)), float64(int(1)<<uint(p+1)-1)
+	t := threshold
+
+	if mode == ModeContainment {
+		// the document's intersection is at least t|X|,
+		// which is hardest to satisfy for small documents
+		return t * lo / (float64(q) + lo - t*lo)
+	}
+
+	// the query's intersection is at least t|Q|,
+	// which is hardest to satisfy for large documents
+	return t * float64(q) / (float64(q) + hi - t*float64(q))
+}
+
+// bandThreshold returns the Jaccard similarity above which documents
+// are likely to share a band, (1/b)^(1/r).
+func (m *MinHasher) bandThreshold() float64 {
+	return math.Pow(1/float64(m.b), 1/float64(m.r))
+}
+
+// shareBand returns true if two band columns have any band in common.
+func shareBand(a, b vector) bool {
+	for j := range a {
+		if a[j] == b[j] {
+			return true
 		}
 	}
 
-	return bcol
+	return false
 }
 
-func (m *MinHasher) bandMatrix() matrix {
-	m.matrixMutex.RLock()
-	defer m.matrixMutex.RUnlock()
+// partition returns the size partition of a document with the given
+// number of shingles. Partition p holds sizes from 2^p to 2^(p+1)-1.
+func partition(size int) int {
+	p := 0
+	for size > 1 {
+		size >>= 1
+		p++
+	}
+
+	return p
+}
 
-	b := make(matrix, len(m.matrix))
+func (m *MinHasher) bandColumn(col vector) vector {
+	bcol := make(vector, m.b)
 
-	for i, col := range m.matrix {
-		bcol := m.bandColumn(col)
-		b[i] = bcol
+	// band i is the hash of the ith group of r rows
+	for i := range bcol {
+		rows := col[i*m.r : (i+1)*m.r]
+		bcol[i] = m.bandHashers.sum(i, rows)
 	}
 
-	return b
+	return bcol
 }
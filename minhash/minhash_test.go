@@ -1,25 +1,36 @@
 package minhash
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/mauidude/deduper/text"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMinHasher(t *testing.T) {
 	mh := New(10, 2, 2)
 
-	mh.Add("1", strings.NewReader(`Lorem ipsum dolor sit amet, consectetur adipiscing elit. Sed sed felis vestibulum, mollis libero eget, pharetra lorem. Sed ut vestibulum tortor. Suspendisse sem nisl, semper eu sem non, tempor viverra ante. Morbi quis nunc non orci fermentum fringilla sit amet nec nisi. Morbi laoreet commodo porta. Ut bibendum porttitor bibendum. Nulla scelerisque eu sem at efficitur. Quisque a imperdiet massa.`))
-	mh.Add("2", strings.NewReader(`Nulla dapibus lorem nunc, nec tempus purus dictum vel. Nullam lacinia ultricies cursus. Ut quis lectus efficitur, porta dolor nec, ornare tellus. Nunc felis orci, scelerisque mollis elementum sed, laoreet mollis sem. Sed sollicitudin massa ultricies ultricies hendrerit. Lorem ipsum dolor sit amet, consectetur adipiscing elit. Nullam finibus lobortis commodo. In dignissim urna a neque lacinia mattis.`))
+	require.NoError(t, mh.Add("1", strings.NewReader(`Lorem ipsum dolor sit amet, consectetur adipiscing elit. Sed sed felis vestibulum, mollis libero eget, pharetra lorem. Sed ut vestibulum tortor. Suspendisse sem nisl, semper eu sem non, tempor viverra ante. Morbi quis nunc non orci fermentum fringilla sit amet nec nisi. Morbi laoreet commodo porta. Ut bibendum porttitor bibendum. Nulla scelerisque eu sem at efficitur. Quisque a imperdiet massa.`)))
+	require.NoError(t, mh.Add("2", strings.NewReader(`Nulla dapibus lorem nunc, nec tempus purus dictum vel. Nullam lacinia ultricies cursus. Ut quis lectus efficitur, porta dolor nec, ornare tellus. Nunc felis orci, scelerisque mollis elementum sed, laoreet mollis sem. Sed sollicitudin massa ultricies ultricies hendrerit. Lorem ipsum dolor sit amet, consectetur adipiscing elit. Nullam finibus lobortis commodo. In dignissim urna a neque lacinia mattis.`)))
 
 	dissimlarText := strings.NewReader(`Cras gravida bibendum venenatis. Nulla tempus ante eget rutrum maximus. Pellentesque vel lorem nisi. Nullam varius neque sed lectus feugiat, ac vestibulum nisi porttitor. Sed risus nisi, ultrices in nisi vitae, convallis congue dolor. Aenean tempor justo quis nisi maximus malesuada. Duis fermentum justo sem, a feugiat velit sagittis eget.`)
-	results := mh.FindSimilar(dissimlarText, 0)
+	results, err := mh.FindSimilar(dissimlarText, 0)
+	require.NoError(t, err)
 
 	assert.Len(t, results, 0)
 
 	similarText := strings.NewReader(`Lorem ipsum dolor sit amet, consectetur adipiscing elit. Sed sed felis vestibulum, mollis libero eget, pharetra lorem. Sed ut vestibulum tortor. Suspendisse sem nisl, semper eu sem non, tempor viverra ante. Morbi quis nunc non orci fermentum fringilla sit amet nec nisi. Morbi laoreet commodo porta. Ut bibendum porttitor bibendum. Blah nulla scelerisque eu sem at efficitur. Quisque a imperdiet massa.`)
-	results = mh.FindSimilar(similarText, .8)
+	results, err = mh.FindSimilar(similarText, .8)
+	require.NoError(t, err)
 
 	assert.Len(t, results, 1)
 	assert.Equal(t, results[0].ID, "1")
@@ -28,3 +39,241 @@ func TestMinHasher(t *testing.T) {
 	assert.True(t, mh.Contains("2"))
 	assert.False(t, mh.Contains("3"))
 }
+
+func TestMinHasher_Normalizers(t *testing.T) {
+	mh, err := NewFromConfig(&Config{
+		Bands:       10,
+		Rows:        2,
+		ShingleSize: 2,
+		Normalizers: text.DefaultNormalizers,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, mh.Add("1", strings.NewReader("Hello, World! How are you today?")))
+
+	results, err := mh.FindSimilar(strings.NewReader("hello world how are you today"), 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	_, err = NewFromConfig(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Normalizers: []string{"bogus"}})
+	assert.Error(t, err)
+}
+
+func TestMinHasher_ReadError(t *testing.T) {
+	mh := New(10, 2, 2)
+	readErr := errors.New("read failed")
+
+	err := mh.Add("1", io.MultiReader(strings.NewReader("a partial document"), iotest.ErrReader(readErr)))
+	assert.Equal(t, readErr, err)
+	assert.False(t, mh.Contains("1"))
+
+	_, err = mh.FindSimilar(iotest.ErrReader(readErr), 0)
+	assert.Equal(t, readErr, err)
+}
+
+func TestMinHasher_ShortDocuments(t *testing.T) {
+	mh := New(10, 2, 3)
+
+	require.NoError(t, mh.Add("1", strings.NewReader("hello")))
+	require.NoError(t, mh.Add("2", strings.NewReader("goodbye")))
+
+	results, err := mh.FindSimilar(strings.NewReader("hello"), 0)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "1", results[0].ID)
+
+	results, err = mh.FindSimilar(strings.NewReader("hi there"), 0)
+	require.NoError(t, err)
+	assert.Len(t, results, 0)
+
+	assert.Equal(t, ErrEmptyDocument, mh.Add("3", strings.NewReader(" ")))
+
+	_, err = mh.FindSimilar(strings.NewReader(""), 0)
+	assert.Equal(t, ErrEmptyDocument, err)
+
+	mh, err = NewFromConfig(&Config{Bands: 10, Rows: 2, ShingleSize: 3, ShortDocuments: ShortReject})
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrDocumentTooShort, mh.Add("1", strings.NewReader("hello")))
+	assert.False(t, mh.Contains("1"))
+}
+
+func TestMinHasher_HashFamilies(t *testing.T) {
+	for _, family := range []string{"universal32", "universal64", "oph"} {
+		mh, err := NewFromConfig(&Config{Bands: 20, Rows: 2, ShingleSize: 2, HashFamily: family})
+		require.NoError(t, err)
+
+		require.NoError(t, mh.Add("1", strings.NewReader("the quick brown fox jumps over the lazy dog while the cat sleeps")))
+		require.NoError(t, mh.Add("2", strings.NewReader("an entirely different sentence about something else altogether")))
+
+		results, err := mh.FindSimilar(strings.NewReader("the quick brown fox jumps over the lazy dog while the cat naps"), .5)
+		require.NoError(t, err)
+
+		if assert.Len(t, results, 1, family) {
+			assert.Equal(t, "1", results[0].ID, family)
+		}
+	}
+}
+
+// randomWords returns n words drawn from a vocabulary large enough
+// that shingles rarely repeat.
+func randomWords(r *rand.Rand, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", r.Intn(100000))
+	}
+
+	return strings.Join(words, " ")
+}
+
+func TestMinHasher_Containment(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	mh, err := NewFromConfig(&Config{Bands: 64, Rows: 4, ShingleSize: 2, HashFamily: "universal64"})
+	require.NoError(t, err)
+
+	paragraph := randomWords(r, 40)
+	article := randomWords(r, 300) + " " + paragraph + " " + randomWords(r, 300)
+
+	require.NoError(t, mh.Add("paragraph", strings.NewReader(paragraph)))
+	require.NoError(t, mh.Add("other", strings.NewReader(randomWords(r, 40))))
+
+	// the paragraph is a small part of the article
+	results, err := mh.Find(strings.NewReader(article), .5, ModeJaccard)
+	require.NoError(t, err)
+	assert.Len(t, results, 0)
+
+	// but all of it is contained in the article
+	results, err = mh.Find(strings.NewReader(article), .7, ModeContainment)
+	require.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "paragraph", results[0].ID)
+		assert.InDelta(t, 1, results[0].Similarity, .3)
+	}
+
+	// and the reverse, a quoted paragraph is contained in a stored article
+	require.NoError(t, mh.Add("article", strings.NewReader(article)))
+
+	results, err = mh.Find(strings.NewReader(paragraph), .7, ModeContained)
+	require.NoError(t, err)
+
+	ids := make([]string, 0)
+	for _, m := range results {
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []string{"paragraph", "article"}, ids)
+}
+
+func TestMinHasher_FindIn(t *testing.T) {
+	mh := New(20, 2, 2)
+
+	doc := "the same document added at different times"
+	hashed, err := mh.Hash(strings.NewReader(doc))
+	require.NoError(t, err)
+
+	start := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		added := start.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, mh.AddHashedAt(fmt.Sprint(i), hashed, NewStamp(added, 0), 0))
+	}
+
+	// the time this document was added is unknown
+	require.NoError(t, mh.AddHashed("unknown", hashed))
+
+	ids := func(matches []Match) []string {
+		var ids []string
+		for _, m := range matches {
+			ids = append(ids, m.ID)
+		}
+
+		return ids
+	}
+
+	for _, mode := range []Mode{ModeJaccard, ModeContainment} {
+		matches, err := mh.FindIn(strings.NewReader(doc), 1, mode, Window{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"0", "1", "2", "unknown"}, ids(matches))
+
+		matches, err = mh.FindIn(strings.NewReader(doc), 1, mode, Window{Since: start.Add(time.Hour)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1", "2"}, ids(matches))
+
+		matches, err = mh.FindIn(strings.NewReader(doc), 1, mode, Window{Until: start.Add(time.Hour)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"0"}, ids(matches))
+
+		matches, err = mh.FindIn(strings.NewReader(doc), 1, mode, Window{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1"}, ids(matches))
+	}
+}
+
+func TestPartition(t *testing.T) {
+	assert.Equal(t, 0, partition(1))
+	assert.Equal(t, 1, partition(2))
+	assert.Equal(t, 1, partition(3))
+	assert.Equal(t, 2, partition(4))
+	assert.Equal(t, 9, partition(1000))
+}
+
+func TestMinHasher_CanContainPartition(t *testing.T) {
+	mh := New(20, 2, 2)
+
+	// documents of 128 shingles or more cannot be contained in 100
+	assert.True(t, mh.canContainPartition(ModeContainment, 100, 6, 1))
+	assert.False(t, mh.canContainPartition(ModeContainment, 100, 7, 1))
+
+	// and documents of 63 shingles or fewer cannot contain 100
+	assert.False(t, mh.canContainPartition(ModeContained, 100, 5, 1))
+	assert.True(t, mh.canContainPartition(ModeContained, 100, 6, 1))
+}
+
+func TestMinHasher_AddHashed(t *testing.T) {
+	c := &Config{Bands: 10, Rows: 2, ShingleSize: 2, Passages: PassageParagraph, StoreShingles: true}
+	leader, err := NewFromConfig(c)
+	require.NoError(t, err)
+
+	hashed, err := leader.Hash(strings.NewReader("the quick brown fox\n\njumps over the lazy dog"))
+	require.NoError(t, err)
+	assert.False(t, leader.Contains("1"))
+
+	// a MinHasher with the same config adds the document without its text
+	follower, err := NewFromConfig(c)
+	require.NoError(t, err)
+	require.NoError(t, follower.AddHashed("1", hashed))
+	assert.True(t, follower.Contains("1"))
+
+	results, err := follower.FindSimilar(strings.NewReader("the quick brown fox\n\njumps over the lazy dog"), 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	passages, err := follower.FindPassages(strings.NewReader("jumps over the lazy dog"), 1)
+	require.NoError(t, err)
+	if assert.Len(t, passages, 1) && assert.Len(t, passages[0].Passages, 1) {
+		assert.Equal(t, 21, passages[0].Passages[0].DocumentStart)
+	}
+
+	e, err := follower.Explain("1", strings.NewReader("the quick brown fox"))
+	require.NoError(t, err)
+	assert.Len(t, e.SharedShingles, 3)
+
+	// signatures of another size are rejected
+	assert.Error(t, New(20, 2, 2).AddHashed("1", hashed))
+	assert.Error(t, follower.AddHashed("2", []byte("not json")))
+
+	// as are signatures hashed with another seed, hash family or b bits
+	for _, other := range []Config{
+		{Bands: 10, Rows: 2, ShingleSize: 2, Seed: 7},
+		{Bands: 10, Rows: 2, ShingleSize: 2, HashFamily: "universal64"},
+		{Bands: 10, Rows: 2, ShingleSize: 2, BBits: 8},
+	} {
+		other := other
+		mh, err := NewFromConfig(&other)
+		require.NoError(t, err)
+		assert.Error(t, mh.AddHashed("1", hashed), other.hashing())
+	}
+
+	// documents hashed before they were fingerprinted are added
+	legacy, err := json.Marshal(map[string]interface{}{"signature": make([]uint32, 20), "size": 1})
+	require.NoError(t, err)
+	assert.NoError(t, follower.AddHashed("3", legacy))
+}
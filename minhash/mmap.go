@@ -0,0 +1,49 @@
+//go:build (linux || darwin) && (amd64 || arm64)
+// +build linux darwin
+// +build amd64 arm64
+
+package minhash
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapFile maps the file at path into memory, read only.
+func mapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() == 0 {
+		return []byte{}, nil
+	}
+
+	return syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile unmaps a file mapped by mapFile.
+func unmapFile(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return syscall.Munmap(data)
+}
+
+// uint32s returns the little endian words of data without copying them.
+func uint32s(data []byte) []uint32 {
+	if len(data) < 4 {
+		return nil
+	}
+
+	return unsafe.Slice((*uint32)(unsafe.Pointer(&data[0])), len(data)/4)
+}
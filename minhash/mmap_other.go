@@ -0,0 +1,30 @@
+//go:build !((linux || darwin) && (amd64 || arm64))
+// +build !linux,!darwin !amd64,!arm64
+
+package minhash
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// mapFile reads the file at path into memory. Files are only
+// memory-mapped on little endian Linux and macOS.
+func mapFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+// unmapFile releases a file read by mapFile.
+func unmapFile(data []byte) error {
+	return nil
+}
+
+// uint32s returns a copy of the little endian words of data.
+func uint32s(data []byte) []uint32 {
+	words := make([]uint32, len(data)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+
+	return words
+}
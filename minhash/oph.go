@@ -0,0 +1,103 @@
+package minhash
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// onePermutation implements one permutation hashing. Each shingle is
+// hashed once and the hash picks one of n bins, which keeps the minimum
+// hash it has seen. This is n times faster than applying n hash functions.
+// Bins left empty by short documents are filled by optimal densification
+// (Shrivastava, 2017), borrowing the value of a randomly chosen non-empty bin.
+type onePermutation struct {
+	n    int
+	seed uint64
+}
+
+func newOnePermutation(n int, r *rand.Rand) *onePermutation {
+	return &onePermutation{
+		n:    n,
+		seed: uint64(r.Int63()),
+	}
+}
+
+func (o *onePermutation) New() Sketch {
+	bins := make([]uint64, o.n)
+	for i := range bins {
+		bins[i] = math.MaxUint64
+	}
+
+	return &onePermutationSketch{
+		o:    o,
+		bins: bins,
+	}
+}
+
+func (o *onePermutation) Similarity(a, b vector) float64 {
+	return agreement(a, b)
+}
+
+type onePermutationSketch struct {
+	o     *onePermutation
+	bins  []uint64
+	empty int
+}
+
+func (s *onePermutationSketch) Add(shingle uint64) {
+	h := Mix64(shingle ^ s.o.seed)
+
+	// the high bits pick the bin, the rest order hashes within it
+	bin, _ := bits.Mul64(h, uint64(s.o.n))
+	if h < s.bins[bin] {
+		s.bins[bin] = h
+	}
+}
+
+func (s *onePermutationSketch) Signature() vector {
+	sig := make(vector, len(s.bins))
+
+	nonEmpty := 0
+	for i, b := range s.bins {
+		sig[i] = uint32(b)
+		if b != math.MaxUint64 {
+			nonEmpty++
+		}
+	}
+
+	if nonEmpty == 0 || nonEmpty == len(s.bins) {
+		return sig
+	}
+
+	// densify: each empty bin probes a sequence of bins, which depends
+	// only on its own index, until it finds a non-empty one
+	for i, b := range s.bins {
+		if b != math.MaxUint64 {
+			continue
+		}
+
+		for attempt := uint64(1); ; attempt++ {
+			h := Mix64(s.o.seed ^ uint64(i)<<32 ^ attempt)
+			j, _ := bits.Mul64(h, uint64(len(s.bins)))
+
+			if s.bins[j] != math.MaxUint64 {
+				sig[i] = uint32(s.bins[j])
+				break
+			}
+		}
+	}
+
+	return sig
+}
+
+// Mix64 is the finalizer of the SplitMix64 generator, which
+// maps each 64-bit value to a well distributed 64-bit value.
+func Mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,159 @@
+package minhash
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// PassageParagraph splits documents into passages at blank lines.
+	PassageParagraph = "paragraph"
+
+	// PassageTokens splits documents into passages of a fixed number of tokens.
+	PassageTokens = "tokens"
+
+	// DefaultPassageSize is the number of tokens in a passage
+	// when using PassageTokens and none is configured.
+	DefaultPassageSize = 100
+)
+
+// ErrPassagesDisabled is returned when passages are
+// queried in an index which does not store them.
+var ErrPassagesDisabled = errors.New("passages are not enabled for the index")
+
+// paragraphBreak separates paragraphs.
+var paragraphBreak = regexp.MustCompile(`\n[ \t\r]*\n\s*`)
+
+// PassageMatch is a document with passages similar
+// to passages of the query document.
+type PassageMatch struct {
+	// ID is the unique ID of the document that was
+	// given when the document was added.
+	ID string `json:"id"`
+
+	// Passages are the pairs of similar passages.
+	Passages []PassagePair `json:"passages"`
+}
+
+// PassagePair is a passage of the query document which is similar to
+// a passage of a stored document. Offsets are rune offsets into the text
+// hashed for each document, which is the text extracted from markup
+// rather than the body it was read from.
+type PassagePair struct {
+	QueryStart    int `json:"query_start"`
+	QueryEnd      int `json:"query_end"`
+	DocumentStart int `json:"document_start"`
+	DocumentEnd   int `json:"document_end"`
+
+	// Similarity is the Jaccard similarity from 0 to 1 of the passages.
+	Similarity float64 `json:"similarity"`
+}
+
+// passage is a region of a document and its signature.
+type passage struct {
+	// the index of the document in the store
+	column int
+
+	// the rune offsets of the passage in the document
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	Signature vector `json:"signature"`
+	bands     vector
+}
+
+// splitPassages returns the byte offsets of the passages of text.
+func (m *MinHasher) splitPassages(text []byte) [][2]int {
+	if m.config.Passages == PassageParagraph {
+		return splitParagraphs(text)
+	}
+
+	size := m.config.PassageSize
+	if size == 0 {
+		size = DefaultPassageSize
+	}
+
+	return m.splitTokens(text, size)
+}
+
+// runeOffsets converts the byte offsets of the passages of text,
+// which are in order, to rune offsets.
+func runeOffsets(text []byte, offsets [][2]int) [][2]int {
+	pos, runes := 0, 0
+	count := func(offset int) int {
+		runes += utf8.RuneCount(text[pos:offset])
+		pos = offset
+		return runes
+	}
+
+	converted := make([][2]int, len(offsets))
+	for i, o := range offsets {
+		converted[i] = [2]int{count(o[0]), count(o[1])}
+	}
+
+	return converted
+}
+
+// splitParagraphs returns the offsets of the paragraphs of text,
+// which are separated by blank lines.
+func splitParagraphs(text []byte) [][2]int {
+	var offsets [][2]int
+
+	start := 0
+	for _, loc := range paragraphBreak.FindAllIndex(text, -1) {
+		offsets = appendPassage(offsets, text, start, loc[0])
+		start = loc[1]
+	}
+
+	return appendPassage(offsets, text, start, len(text))
+}
+
+// splitTokens returns the offsets of consecutive windows of size tokens.
+func (m *MinHasher) splitTokens(text []byte, size int) [][2]int {
+	var offsets [][2]int
+
+	start, end, tokens := -1, 0, 0
+	for pos := 0; pos < len(text); {
+		advance, token, err := m.analyzer.Split(text[pos:], true)
+		if err != nil || advance == 0 {
+			break
+		}
+
+		if token != nil {
+			// tokens are slices of the text, so their offset is known
+			offset := cap(text) - cap(token)
+			if start < 0 {
+				start = offset
+			}
+			end = offset + len(token)
+
+			if tokens++; tokens == size {
+				offsets = append(offsets, [2]int{start, end})
+				start, tokens = -1, 0
+			}
+		}
+
+		pos += advance
+	}
+
+	if start >= 0 {
+		offsets = append(offsets, [2]int{start, end})
+	}
+
+	return offsets
+}
+
+// appendPassage appends the offsets of text[start:end], without
+// surrounding whitespace, if it is not empty.
+func appendPassage(offsets [][2]int, text []byte, start, end int) [][2]int {
+	end = start + len(bytes.TrimRightFunc(text[start:end], unicode.IsSpace))
+	start = end - len(bytes.TrimLeftFunc(text[start:end], unicode.IsSpace))
+	if start == end {
+		return offsets
+	}
+
+	return append(offsets, [2]int{start, end})
+}
@@ -0,0 +1,91 @@
+package minhash
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mauidude/deduper/text"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitParagraphs(t *testing.T) {
+	text := []byte("  first paragraph\nstill first\n\n \n second one\r\n\r\nthird  \n")
+
+	offsets := splitParagraphs(text)
+
+	passages := make([]string, len(offsets))
+	for i, o := range offsets {
+		passages[i] = string(text[o[0]:o[1]])
+	}
+
+	assert.Equal(t, []string{"first paragraph\nstill first", "second one", "third"}, passages)
+	assert.Len(t, splitParagraphs([]byte(" \n\n ")), 0)
+}
+
+func TestSplitTokens(t *testing.T) {
+	mh, err := NewFromConfig(&Config{Bands: 10, Rows: 2, ShingleSize: 2, Passages: PassageTokens, PassageSize: 3})
+	require.NoError(t, err)
+
+	text := []byte(" one two  three four\tfive six seven ")
+
+	passages := make([]string, 0)
+	for _, o := range mh.splitPassages(text) {
+		passages = append(passages, string(text[o[0]:o[1]]))
+	}
+
+	assert.Equal(t, []string{"one two  three", "four\tfive six", "seven"}, passages)
+}
+
+func TestMinHasher_FindPassages(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	mh, err := NewFromConfig(&Config{Bands: 20, Rows: 2, ShingleSize: 2, Passages: PassageParagraph})
+	require.NoError(t, err)
+
+	quoted := randomWords(r, 30)
+	document := randomWords(r, 30) + "\n\n" + quoted + "\n\n" + randomWords(r, 30)
+	require.NoError(t, mh.Add("1", strings.NewReader(document)))
+	require.NoError(t, mh.Add("2", strings.NewReader(randomWords(r, 30))))
+
+	query := randomWords(r, 50) + "\n\n" + quoted
+	matches, err := mh.FindPassages(strings.NewReader(query), .8)
+	require.NoError(t, err)
+
+	if assert.Len(t, matches, 1) && assert.Len(t, matches[0].Passages, 1) {
+		assert.Equal(t, "1", matches[0].ID)
+
+		p := matches[0].Passages[0]
+		assert.Equal(t, quoted, query[p.QueryStart:p.QueryEnd])
+		assert.Equal(t, quoted, document[p.DocumentStart:p.DocumentEnd])
+		assert.Equal(t, 1.0, p.Similarity)
+	}
+
+	// passages are only stored if they are enabled
+	_, err = New(10, 2, 2).FindPassages(strings.NewReader(query), .8)
+	assert.Equal(t, ErrPassagesDisabled, err)
+}
+
+func TestMinHasher_FindPassagesRuneOffsets(t *testing.T) {
+	mh, err := NewFromConfig(&Config{Bands: 20, Rows: 2, ShingleSize: 2, Passages: PassageTokens, PassageSize: 4})
+	require.NoError(t, err)
+
+	html := "<html><body><h1>Über alles</h1><p>naïve café <b>crème</b> brûlée déjà vu</p></body></html>"
+	require.NoError(t, mh.Add("1", text.NewHTMLReader(strings.NewReader(html))))
+
+	extracted, err := ioutil.ReadAll(text.NewHTMLReader(strings.NewReader(html)))
+	require.NoError(t, err)
+
+	query := "crème brûlée déjà vu"
+	matches, err := mh.FindPassages(strings.NewReader(query), 1)
+	require.NoError(t, err)
+
+	// the offsets count runes of the text extracted from the markup
+	if assert.Len(t, matches, 1) && assert.Len(t, matches[0].Passages, 1) {
+		p := matches[0].Passages[0]
+		assert.Equal(t, 0, p.QueryStart)
+		assert.Equal(t, 20, p.QueryEnd)
+		assert.Equal(t, query, string([]rune(string(extracted))[p.DocumentStart:p.DocumentEnd]))
+	}
+}
@@ -0,0 +1,359 @@
+package minhash
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const (
+	// the first word of a segment file, "DSEG"
+	segmentMagic = 0x47455344
+
+	// the version of the segment file format; version 1
+	// segments have no stamps, and are still read
+	segmentVersion = 2
+
+	// the number of words in the header of a segment file
+	segmentHeaderWords = 8
+)
+
+// segment is an immutable list of columns in a memory-mapped file. All the
+// values are little endian uint32s, in these sections:
+//
+//	header      magic, version, hashes, bits, bands, columns, id bytes, 0
+//	signatures  the packed signature of each column
+//	bands       the band hashes of each column
+//	sizes       the number of distinct shingles of each column
+//	stamps      when each column was added and expires, as the low
+//	            and high words of each
+//	buckets     for each band, (hash, column) pairs sorted by hash
+//	id offsets  the offset of the id of each column, and the end of the ids
+//	id order    the columns sorted by id, then by column
+//	ids         the bytes of the ids, padded to a whole word
+//
+// Columns removed after the segment was written are marked in memory, and
+// the marks are kept in the manifest until the segment is compacted.
+type segment struct {
+	*layout
+
+	// the removed columns
+	tombstones
+
+	// the path of the file
+	path string
+
+	// the mapped file and the words of its sections
+	data       []byte
+	signatures []uint32
+	bands      []uint32
+	sizes      []uint32
+	stamps     []uint32
+	buckets    []uint32
+	idOffsets  []uint32
+	idOrder    []uint32
+	ids        []byte
+
+	// the number of columns
+	n int
+}
+
+// columnRef is a column of a list of columns.
+type columnRef struct {
+	part columns
+	i    int
+}
+
+// liveColumns returns the columns of parts, in order,
+// leaving out those which were removed.
+func liveColumns(parts []columns) []columnRef {
+	var refs []columnRef
+	for _, p := range parts {
+		for i := 0; i < p.len(); i++ {
+			if !p.removed(i) {
+				refs = append(refs, columnRef{p, i})
+			}
+		}
+	}
+
+	return refs
+}
+
+// writeSegment writes the columns refs, in order, to a new segment
+// file at path. The file is only complete once it is renamed, so an
+// interrupted write never leaves a partial segment.
+func writeSegment(path string, l *layout, refs []columnRef) error {
+	n := len(refs)
+	if n == 0 {
+		return errors.New("segment has no columns")
+	}
+
+	ids := make([]string, n)
+	idBytes := 0
+	for c, r := range refs {
+		ids[c] = r.part.id(r.i)
+		idBytes += len(ids[c])
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	write := func(v interface{}) {
+		if err == nil {
+			err = binary.Write(w, binary.LittleEndian, v)
+		}
+	}
+
+	write([]uint32{segmentMagic, segmentVersion, uint32(l.hashes), uint32(l.bits), uint32(l.b), uint32(n), uint32(idBytes), 0})
+
+	for _, r := range refs {
+		write(r.part.packed(r.i))
+	}
+
+	for _, r := range refs {
+		write([]uint32(r.part.band(r.i)))
+	}
+
+	for _, r := range refs {
+		write(uint32(r.part.size(r.i)))
+	}
+
+	for _, r := range refs {
+		st := r.part.stamp(r.i)
+		write([]uint32{uint32(st.added), uint32(st.added >> 32), uint32(st.expires), uint32(st.expires >> 32)})
+	}
+
+	// the buckets of each band are sorted by hash, then by column
+	pairs := make([]uint64, n)
+	for j := 0; j < l.b; j++ {
+		for c, r := range refs {
+			pairs[c] = uint64(r.part.band(r.i)[j])<<32 | uint64(c)
+		}
+		sort.Slice(pairs, func(a, b int) bool { return pairs[a] < pairs[b] })
+
+		for _, p := range pairs {
+			write([]uint32{uint32(p >> 32), uint32(p)})
+		}
+	}
+
+	offset := uint32(0)
+	for _, id := range ids {
+		write(offset)
+		offset += uint32(len(id))
+	}
+	write(offset)
+
+	order := make([]uint32, n)
+	for c := range order {
+		order[c] = uint32(c)
+	}
+	sort.SliceStable(order, func(a, b int) bool { return ids[order[a]] < ids[order[b]] })
+	write(order)
+
+	for _, id := range ids {
+		if err == nil {
+			_, err = w.WriteString(id)
+		}
+	}
+	write(make([]byte, (4-idBytes%4)%4))
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// openSegment maps the segment file at path, which must
+// hold signatures of the given layout.
+func openSegment(path string, l *layout) (*segment, error) {
+	data, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := parseSegment(path, l, data)
+	if err != nil {
+		unmapFile(data)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func parseSegment(path string, l *layout, data []byte) (*segment, error) {
+	if len(data) < 4*segmentHeaderWords || len(data)%4 != 0 {
+		return nil, fmt.Errorf("segment %s is truncated", path)
+	}
+
+	words := uint32s(data)
+	header := words[:segmentHeaderWords]
+	if header[0] != segmentMagic || header[1] < 1 || header[1] > segmentVersion {
+		return nil, fmt.Errorf("%s is not a segment file", path)
+	}
+
+	if int(header[2]) != l.hashes || uint(header[3]) != l.bits || int(header[4]) != l.b {
+		return nil, fmt.Errorf("segment %s was written with a different config", path)
+	}
+
+	n := int(header[5])
+	idBytes := int(header[6])
+
+	s := &segment{
+		layout: l,
+		path:   path,
+		data:   data,
+		n:      n,
+	}
+
+	sections := []struct {
+		words *[]uint32
+		len   int
+	}{
+		{&s.signatures, n * l.words},
+		{&s.bands, n * l.b},
+		{&s.sizes, n},
+		{&s.stamps, 0},
+		{&s.buckets, 2 * n * l.b},
+		{&s.idOffsets, n + 1},
+		{&s.idOrder, n},
+	}
+
+	if header[1] >= 2 {
+		sections[3].len = 4 * n
+	}
+
+	offset := segmentHeaderWords
+	for _, section := range sections {
+		if offset+section.len > len(words) {
+			return nil, fmt.Errorf("segment %s is truncated", path)
+		}
+
+		*section.words = words[offset : offset+section.len]
+		offset += section.len
+	}
+
+	if 4*offset+idBytes > len(data) {
+		return nil, fmt.Errorf("segment %s is truncated", path)
+	}
+	s.ids = data[4*offset : 4*offset+idBytes]
+
+	return s, nil
+}
+
+func (s *segment) len() int {
+	return s.n
+}
+
+// idBytes returns the bytes of the id of the ith column.
+func (s *segment) idBytes(i int) []byte {
+	return s.ids[s.idOffsets[i]:s.idOffsets[i+1]]
+}
+
+func (s *segment) id(i int) string {
+	// the string is a copy, so it outlives the mapping
+	return string(s.idBytes(i))
+}
+
+func (s *segment) size(i int) int {
+	return int(s.sizes[i])
+}
+
+func (s *segment) band(i int) vector {
+	return s.bands[i*s.b : (i+1)*s.b]
+}
+
+func (s *segment) packed(i int) []uint32 {
+	return s.signatures[i*s.words : (i+1)*s.words]
+}
+
+func (s *segment) stamp(i int) stamp {
+	if len(s.stamps) == 0 {
+		return stamp{}
+	}
+
+	w := s.stamps[4*i : 4*i+4]
+	return stamp{
+		added:   int64(w[0]) | int64(w[1])<<32,
+		expires: int64(w[2]) | int64(w[3])<<32,
+	}
+}
+
+func (s *segment) last(id string) int {
+	key := []byte(id)
+
+	// the first column in the id order whose id is after id
+	k := sort.Search(s.n, func(k int) bool {
+		return bytes.Compare(s.idBytes(int(s.idOrder[k])), key) > 0
+	})
+
+	// the columns of an id are in order, so the latest is the last
+	for ; k > 0 && bytes.Equal(s.idBytes(int(s.idOrder[k-1])), key); k-- {
+		if i := int(s.idOrder[k-1]); !s.removed(i) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (s *segment) candidates(bands vector) []int {
+	var c []int
+	for j, h := range bands {
+		bucket := s.buckets[2*j*s.n : 2*(j+1)*s.n]
+
+		k := sort.Search(s.n, func(k int) bool {
+			return bucket[2*k] >= h
+		})
+
+		for ; k < s.n && bucket[2*k] == h; k++ {
+			if i := int(bucket[2*k+1]); !s.removed(i) {
+				c = append(c, i)
+			}
+		}
+	}
+
+	return uniqueInts(c)
+}
+
+// bytes returns the size of the mapped file, which the
+// operating system pages in and out of memory as needed.
+func (s *segment) bytes() int64 {
+	return int64(len(s.data)) + int64(cap(s.marks))
+}
+
+func (s *segment) close() error {
+	return unmapFile(s.data)
+}
+
+// uniqueInts sorts c and removes duplicates.
+func uniqueInts(c []int) []int {
+	sort.Ints(c)
+
+	u := c[:0]
+	for i, v := range c {
+		if i == 0 || v != c[i-1] {
+			u = append(u, v)
+		}
+	}
+
+	return u
+}
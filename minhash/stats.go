@@ -0,0 +1,53 @@
+package minhash
+
+// Stats describes the documents in an index and the memory they use.
+type Stats struct {
+	// Documents is the number of documents added which have not
+	// expired, including documents added more than once with the
+	// same id.
+	Documents int `json:"documents"`
+
+	// IDs is the number of distinct document ids.
+	IDs int `json:"ids"`
+
+	// Bytes is the approximate number of bytes used by the documents.
+	Bytes int64 `json:"bytes"`
+
+	// BytesPerDocument is the average number of bytes used by a document.
+	BytesPerDocument float64 `json:"bytes_per_document"`
+}
+
+// NewStats returns the stats of an index with the given number
+// of documents and ids which uses the given number of bytes.
+func NewStats(documents int, ids int, bytes int64) Stats {
+	s := Stats{
+		Documents: documents,
+		IDs:       ids,
+		Bytes:     bytes,
+	}
+
+	if documents > 0 {
+		s.BytesPerDocument = float64(bytes) / float64(documents)
+	}
+
+	return s
+}
+
+// Stats returns the number of documents in the MinHasher
+// and the memory they use.
+func (m *MinHasher) Stats() Stats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	bytes := m.store.bytes()
+
+	for _, hashes := range m.shingles {
+		bytes += 24 + 8*int64(cap(hashes))
+	}
+
+	for _, p := range m.passages {
+		bytes += 64 + 4*int64(cap(p.Signature)+cap(p.bands))
+	}
+
+	return NewStats(m.store.len()-m.store.dead(), m.store.ids(), bytes)
+}
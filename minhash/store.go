@@ -0,0 +1,429 @@
+package minhash
+
+import (
+	"math"
+)
+
+// columns is a read-only list of hashed documents.
+type columns interface {
+	// len returns the number of columns, including removed columns.
+	len() int
+
+	// id returns the id of the ith column.
+	id(i int) string
+
+	// size returns the number of distinct shingles of the ith column.
+	size(i int) int
+
+	// band returns the band hashes of the ith column.
+	band(i int) vector
+
+	// packed returns the packed signature of the ith column.
+	packed(i int) []uint32
+
+	// stamp returns when the ith column was added and expires.
+	stamp(i int) stamp
+
+	// removed returns true if the ith column was removed.
+	removed(i int) bool
+
+	// dead returns the number of removed columns.
+	dead() int
+
+	// last returns the index of the latest column with the given
+	// id which was not removed, or -1.
+	last(id string) int
+
+	// candidates returns, in increasing order, the columns which share
+	// at least one band with the given band hashes and were not removed.
+	candidates(bands vector) []int
+
+	// bytes returns the approximate number of bytes of memory used.
+	bytes() int64
+}
+
+// columnStore stores the columns of a MinHasher.
+type columnStore interface {
+	columns
+
+	// add adds a column for the raft log entry with the given index,
+	// which is zero if the column was not added through the log.
+	add(id string, signature vector, bands vector, size int, st stamp, index uint64)
+
+	// remove removes the ith column for the raft log entry with the given
+	// index. Removed columns keep their index until the store is purged.
+	remove(i int, index uint64)
+
+	// applied returns the index of the latest raft log entry added.
+	applied() uint64
+
+	// ids returns the number of distinct ids of the columns
+	// which were not removed.
+	ids() int
+
+	// hold stops the store renumbering its columns, by dropping those which
+	// were removed, until release is called. Columns can then be read in
+	// several batches.
+	hold()
+	release()
+
+	// close releases the resources of the store.
+	close() error
+}
+
+// stamp is when the leader added a column and when it expires, in Unix
+// nanoseconds. Either is zero if it is unknown or never.
+type stamp struct {
+	added   int64
+	expires int64
+}
+
+// expired returns true if the column expires at or before t.
+func (st stamp) expired(t int64) bool {
+	return st.expires != 0 && st.expires <= t
+}
+
+// tombstones marks the removed columns of a list of columns.
+type tombstones struct {
+	// true for each removed column, it may be shorter than the list
+	marks []bool
+
+	// the number of removed columns
+	count int
+}
+
+func (t *tombstones) removed(i int) bool {
+	return i < len(t.marks) && t.marks[i]
+}
+
+func (t *tombstones) dead() int {
+	return t.count
+}
+
+// mark marks the ith column of a list of n columns as removed.
+// It returns false if the column was already removed.
+func (t *tombstones) mark(i int, n int) bool {
+	if t.removed(i) {
+		return false
+	}
+
+	if len(t.marks) < n {
+		t.marks = append(t.marks, make([]bool, n-len(t.marks))...)
+	}
+
+	t.marks[i] = true
+	t.count++
+	return true
+}
+
+// list returns the removed columns in increasing order.
+func (t *tombstones) list() []int {
+	l := make([]int, 0, t.count)
+	for i, dead := range t.marks {
+		if dead {
+			l = append(l, i)
+		}
+	}
+
+	return l
+}
+
+// layout is the shape of the signatures in a store. Only the low bits of
+// each hash may be kept, in which case several hashes are packed in a word.
+type layout struct {
+	// the number of hashes in each signature
+	hashes int
+
+	// the number of low bits kept of each hash
+	bits uint
+
+	// the number of uint32 words used by each signature
+	words int
+
+	// the number of bands of each column
+	b int
+}
+
+// newLayout returns the layout of signatures of the given number of
+// hashes, keeping the low bits of each hash, and their b bands.
+func newLayout(hashes int, bits int, b int) *layout {
+	if bits == 0 {
+		bits = 32
+	}
+
+	perWord := 32 / bits
+	return &layout{
+		hashes: hashes,
+		bits:   uint(bits),
+		words:  (hashes + perWord - 1) / perWord,
+		b:      b,
+	}
+}
+
+// signature returns the signature of a column from its packed
+// words. If only some bits of each hash are kept, it is a copy.
+func (l *layout) signature(words []uint32) vector {
+	if l.bits == 32 {
+		return words
+	}
+
+	return l.unpack(words)
+}
+
+// truncate returns the signature with only the bits kept by the layout.
+func (l *layout) truncate(sig vector) vector {
+	if l.bits == 32 {
+		return sig
+	}
+
+	return l.unpack(l.pack(sig))
+}
+
+// pack packs the low bits of each hash of a signature into words.
+func (l *layout) pack(sig vector) []uint32 {
+	if l.bits == 32 {
+		return sig
+	}
+
+	perWord := 32 / int(l.bits)
+	mask := uint32(1)<<l.bits - 1
+
+	words := make([]uint32, l.words)
+	for j, h := range sig {
+		words[j/perWord] |= (h & mask) << (uint(j%perWord) * l.bits)
+	}
+
+	return words
+}
+
+// unpack unpacks the hashes of a signature packed by pack.
+func (l *layout) unpack(words []uint32) vector {
+	perWord := 32 / int(l.bits)
+	mask := uint32(1)<<l.bits - 1
+
+	sig := make(vector, l.hashes)
+	for j := range sig {
+		sig[j] = words[j/perWord] >> (uint(j%perWord) * l.bits) & mask
+	}
+
+	return sig
+}
+
+// memStore holds columns in memory in a compact columnar layout.
+// The signatures and bands of all the columns are kept in contiguous
+// arenas, and each column refers to its id by a dense index into a
+// table of ids, so a column costs only a few small allocations.
+type memStore struct {
+	*layout
+
+	// the removed columns
+	tombstones
+
+	// the packed signatures of all the columns
+	signatures []uint32
+
+	// the band hashes of all the columns
+	bands []uint32
+
+	// the number of distinct shingles of each column
+	sizes []uint32
+
+	// when each column was added and expires
+	stamps []stamp
+
+	// the index in names of the id of each column
+	columnIDs []int32
+
+	// the ids of the documents and their index in names
+	names   []string
+	nameIDs map[string]int32
+
+	// the number of columns of each name which were not removed,
+	// and the number of names with at least one
+	live     []int32
+	distinct int
+
+	// the latest column of each name which was not removed, or -1,
+	// and the previous column of the name of each column, or -1
+	latest   []int32
+	previous []int32
+
+	// the index of the latest raft log entry added
+	index uint64
+
+	// the number of readers holding the column numbers
+	holds int
+}
+
+// newMemStore creates an empty memStore.
+func newMemStore(l *layout) *memStore {
+	return &memStore{
+		layout:  l,
+		nameIDs: make(map[string]int32),
+	}
+}
+
+func (s *memStore) len() int {
+	return len(s.columnIDs)
+}
+
+func (s *memStore) add(id string, signature vector, bands vector, size int, st stamp, index uint64) {
+	name, ok := s.nameIDs[id]
+	if !ok {
+		name = int32(len(s.names))
+		s.names = append(s.names, id)
+		s.nameIDs[id] = name
+		s.live = append(s.live, 0)
+		s.latest = append(s.latest, -1)
+	}
+
+	if s.live[name] == 0 {
+		s.distinct++
+	}
+	s.live[name]++
+
+	s.previous = append(s.previous, s.latest[name])
+	s.latest[name] = int32(s.len())
+
+	s.signatures = append(s.signatures, s.pack(signature)...)
+	s.bands = append(s.bands, bands...)
+	s.sizes = append(s.sizes, uint32(size))
+	s.stamps = append(s.stamps, st)
+	s.columnIDs = append(s.columnIDs, name)
+
+	if index > s.index {
+		s.index = index
+	}
+}
+
+func (s *memStore) remove(i int, index uint64) {
+	if s.mark(i, s.len()) {
+		name := s.columnIDs[i]
+		s.live[name]--
+		if s.live[name] == 0 {
+			s.distinct--
+		}
+
+		// the latest column of the name is now the one added before
+		// it, or before that if it was removed
+		if int(s.latest[name]) == i {
+			j := s.previous[i]
+			for j >= 0 && s.removed(int(j)) {
+				j = s.previous[j]
+			}
+			s.latest[name] = j
+		}
+	}
+
+	if index > s.index {
+		s.index = index
+	}
+}
+
+func (s *memStore) applied() uint64 {
+	return s.index
+}
+
+func (s *memStore) ids() int {
+	return s.distinct
+}
+
+func (s *memStore) id(i int) string {
+	return s.names[s.columnIDs[i]]
+}
+
+func (s *memStore) last(id string) int {
+	name, ok := s.nameIDs[id]
+	if !ok {
+		return -1
+	}
+
+	return int(s.latest[name])
+}
+
+func (s *memStore) size(i int) int {
+	return int(s.sizes[i])
+}
+
+func (s *memStore) band(i int) vector {
+	return s.bands[i*s.b : (i+1)*s.b]
+}
+
+func (s *memStore) packed(i int) []uint32 {
+	return s.signatures[i*s.words : (i+1)*s.words]
+}
+
+func (s *memStore) stamp(i int) stamp {
+	return s.stamps[i]
+}
+
+func (s *memStore) candidates(bands vector) []int {
+	var c []int
+	for i := 0; i < s.len(); i++ {
+		if !s.removed(i) && shareBand(bands, s.band(i)) {
+			c = append(c, i)
+		}
+	}
+
+	return c
+}
+
+func (s *memStore) hold() {
+	s.holds++
+}
+
+func (s *memStore) release() {
+	s.holds--
+}
+
+// purge drops the removed columns, unless the column numbers are held,
+// and returns the new index of each column, which is -1 for those which
+// were dropped. It returns nil if nothing was dropped.
+func (s *memStore) purge() []int {
+	if s.count == 0 || s.holds > 0 {
+		return nil
+	}
+
+	kept := newMemStore(s.layout)
+	kept.index = s.index
+
+	renumber := make([]int, s.len())
+	for i := range renumber {
+		if s.removed(i) {
+			renumber[i] = -1
+			continue
+		}
+
+		renumber[i] = kept.len()
+		kept.add(s.id(i), s.signature(s.packed(i)), s.band(i), s.size(i), s.stamp(i), 0)
+	}
+
+	*s = *kept
+	return renumber
+}
+
+func (s *memStore) bytes() int64 {
+	n := 4*int64(cap(s.signatures)+cap(s.bands)+cap(s.sizes)+cap(s.columnIDs)+
+		cap(s.live)+cap(s.latest)+cap(s.previous)) +
+		16*int64(cap(s.stamps)) + int64(cap(s.marks))
+
+	// each id is held by the table and the map, which
+	// costs roughly 48 bytes per entry on top of the string
+	for _, name := range s.names {
+		n += int64(len(name)) + 16 + 48
+	}
+
+	return n
+}
+
+func (s *memStore) close() error {
+	return nil
+}
+
+// bbitSimilarity estimates the Jaccard similarity from the fraction of hashes
+// whose low bits agree. Unrelated hashes agree by chance with probability
+// 2^-bits, which is removed from the estimate (Li and Konig, 2010).
+func bbitSimilarity(agree float64, bits uint) float64 {
+	chance := math.Pow(2, -float64(bits))
+	return math.Max(0, (agree-chance)/(1-chance))
+}
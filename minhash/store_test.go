@@ -0,0 +1,105 @@
+package minhash
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStore(t *testing.T) {
+	s := newMemStore(newLayout(3, 0, 1))
+
+	s.add("a", vector{1, 2, 3}, vector{7}, 10, stamp{}, 0)
+	s.add("b", vector{4, 5, 6}, vector{8}, 20, stamp{}, 4)
+	s.add("a", vector{7, 8, 9}, vector{7}, 30, stamp{}, 3)
+
+	assert.Equal(t, 3, s.len())
+	assert.Equal(t, 2, s.ids())
+	assert.Equal(t, uint64(4), s.applied())
+	assert.Equal(t, "a", s.id(2))
+	assert.Equal(t, []uint32{4, 5, 6}, s.packed(1))
+	assert.Equal(t, vector{7}, s.band(2))
+	assert.Equal(t, 20, s.size(1))
+	assert.Equal(t, []int{0, 2}, s.candidates(vector{7}))
+
+	assert.Equal(t, 2, s.last("a"))
+	assert.Equal(t, 1, s.last("b"))
+	assert.Equal(t, -1, s.last("c"))
+
+	// the latest column of an id which was not removed
+	s.remove(2, 5)
+	assert.Equal(t, 0, s.last("a"))
+	s.remove(0, 6)
+	assert.Equal(t, -1, s.last("a"))
+	assert.Equal(t, 1, s.ids())
+
+	s.add("a", vector{1, 2, 3}, vector{7}, 10, stamp{}, 7)
+	assert.Equal(t, 3, s.last("a"))
+	assert.Equal(t, 1, s.last("b"))
+}
+
+func TestLayout_BBits(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+
+	for _, bits := range []int{1, 2, 4, 8, 16} {
+		l := newLayout(25, bits, 5)
+
+		sig := make(vector, 25)
+		for i := range sig {
+			sig[i] = r.Uint32()
+		}
+
+		packed := l.pack(sig)
+		assert.Len(t, packed, (25*bits+31)/32, "bits %d", bits)
+
+		// only the low bits of each hash are kept
+		mask := uint32(1)<<uint(bits) - 1
+		for i, h := range l.signature(packed) {
+			assert.Equal(t, sig[i]&mask, h)
+		}
+
+		assert.Equal(t, l.signature(packed), l.truncate(sig))
+	}
+}
+
+func TestMinHasher_BBits(t *testing.T) {
+	mh, err := NewFromConfig(&Config{Bands: 50, Rows: 2, ShingleSize: 2, HashFamily: "universal64", BBits: 4})
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(7))
+	document := randomWords(r, 200)
+	require.NoError(t, mh.Add("1", strings.NewReader(document)))
+	require.NoError(t, mh.Add("2", strings.NewReader(randomWords(r, 200))))
+
+	results, err := mh.FindSimilar(strings.NewReader(document), .9)
+	require.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "1", results[0].ID)
+		assert.Equal(t, 1.0, results[0].Similarity)
+	}
+
+	// unrelated documents agree on a sixteenth of their
+	// hashes by chance, which is not counted as similarity
+	a, b := mh.layout.signature(mh.store.packed(0)), mh.layout.signature(mh.store.packed(1))
+	assert.InDelta(t, 0, mh.estimate(a, b), .15)
+
+	// 100 hashes of 4 bits fit in 13 words
+	assert.Len(t, mh.store.packed(0), 13)
+}
+
+func TestMinHasher_Stats(t *testing.T) {
+	mh := New(10, 2, 2)
+	assert.Equal(t, Stats{}, mh.Stats())
+
+	require.NoError(t, mh.Add("1", strings.NewReader("the quick brown fox")))
+	require.NoError(t, mh.Add("1", strings.NewReader("jumps over the lazy dog")))
+
+	stats := mh.Stats()
+	assert.Equal(t, 2, stats.Documents)
+	assert.Equal(t, 1, stats.IDs)
+	assert.True(t, stats.Bytes >= 2*4*20)
+	assert.Equal(t, float64(stats.Bytes)/2, stats.BytesPerDocument)
+}
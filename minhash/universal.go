@@ -0,0 +1,136 @@
+package minhash
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// The Mersenne prime 2^61-1 used as the modulus of 64-bit universal hashing.
+const mersenne61 = uint64(1)<<61 - 1
+
+// universal32 folds the hash of each shingle to 32 bits and then
+// applies n universal hash functions to it. The similarity of two
+// signatures is the Jaccard similarity of their sets of values.
+type universal32 struct {
+	hashers *hashers
+}
+
+func newUniversal32(n int, r *rand.Rand) *universal32 {
+	return &universal32{
+		hashers: generateHashers(n, p1, r),
+	}
+}
+
+func (u *universal32) New() Sketch {
+	return &universal32Sketch{
+		u:   u,
+		sig: newSignature(u.hashers.len()),
+	}
+}
+
+func (u *universal32) Similarity(a, b vector) float64 {
+	return jaccard(a, b)
+}
+
+type universal32Sketch struct {
+	u   *universal32
+	sig vector
+}
+
+func (s *universal32Sketch) Add(shingle uint64) {
+	s.u.hashers.min(s.sig, uint32(shingle^shingle>>32))
+}
+
+func (s *universal32Sketch) Signature() vector {
+	return s.sig
+}
+
+// universal64 applies n universal hash functions (ax+b) mod 2^61-1 to
+// the 64-bit hash of each shingle, so large corpora avoid the collisions
+// of 32-bit hashes. The low 32 bits of each minimum are kept.
+type universal64 struct {
+	a []uint64
+	b []uint64
+}
+
+func newUniversal64(n int, r *rand.Rand) *universal64 {
+	u := &universal64{
+		a: make([]uint64, n),
+		b: make([]uint64, n),
+	}
+
+	for i := 0; i < n; i++ {
+		u.a[i] = uint64(r.Int63n(int64(mersenne61-1))) + 1
+		u.b[i] = uint64(r.Int63n(int64(mersenne61)))
+	}
+
+	return u
+}
+
+func (u *universal64) New() Sketch {
+	mins := make([]uint64, len(u.a))
+	for i := range mins {
+		mins[i] = math.MaxUint64
+	}
+
+	return &universal64Sketch{
+		u:    u,
+		mins: mins,
+	}
+}
+
+func (u *universal64) Similarity(a, b vector) float64 {
+	return agreement(a, b)
+}
+
+type universal64Sketch struct {
+	u    *universal64
+	mins []uint64
+}
+
+func (s *universal64Sketch) Add(shingle uint64) {
+	x := shingle % mersenne61
+	a, b, mins := s.u.a, s.u.b, s.mins
+
+	for i := range mins {
+		h := mulAddMod61(a[i], x, b[i])
+		if h < mins[i] {
+			mins[i] = h
+		}
+	}
+}
+
+func (s *universal64Sketch) Signature() vector {
+	sig := make(vector, len(s.mins))
+	for i, m := range s.mins {
+		sig[i] = uint32(m)
+	}
+
+	return sig
+}
+
+// mulAddMod61 returns (a*x + b) mod 2^61-1 for a, x, b less than 2^61-1.
+func mulAddMod61(a, x, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, x)
+
+	// a*x = hi*2^64 + lo and 2^61 = 1 (mod 2^61-1)
+	r := (lo & mersenne61) + (lo >> 61) + (hi << 3) + b
+	r = (r & mersenne61) + (r >> 61)
+	if r >= mersenne61 {
+		r -= mersenne61
+	}
+
+	return r
+}
+
+// newSignature returns a signature of size n with every value
+// initialized to the maximum so the minimums can be found.
+func newSignature(n int) vector {
+	sig := make(vector, n)
+	for i := range sig {
+		sig[i] = math.MaxUint32
+	}
+
+	return sig
+}
@@ -1,20 +1,12 @@
 package minhash
 
 import (
-	"hash/fnv"
 	"math"
 	"math/rand"
 
 	"github.com/deckarep/golang-set"
 )
 
-// hashCode returns a hash value for a given string.
-func hashCode(s string) uint32 {
-	h := fnv.New32a()
-	h.Write([]byte(s))
-	return h.Sum32()
-}
-
 // jaccard returns the Jaccard similarity of two vectors.
 // The result will be between 0 and 1, inclusively, where 0 is not at all similar
 // and 1 is identical.
@@ -38,12 +30,21 @@ func jaccard(a, b vector) float64 {
 	return float64(intersection) / float64(union)
 }
 
+// hashers is a set of universal hashing functions in the form
+// ((ax+b) % p) % m. The coefficients are kept in flat arrays so
+// all of the functions can be applied in a tight loop.
+type hashers struct {
+	a []uint64
+	b []uint64
+	p uint64
+}
+
 // generateHashers creates a set of n universal hashing functions
 // in the form ((ax+b) % p) % m. a and b are generated uniquely
-// for each hash function. p should be a large prime number. m
-// is the maximum hash value + 1 which is the maximum value of a uint64
+// for each hash function from r. p should be a large prime number. m
+// is the maximum hash value + 1 which is the maximum value of a uint32
 // in this case.
-func generateHahsers(n int, p uint64) []hasher {
+func generateHashers(n int, p uint64, r *rand.Rand) *hashers {
 	// universal hashing
 	// h(x,a,b) = ((ax+b) mod p) mod m
 	// x is key you want to hash
@@ -53,28 +54,47 @@ func generateHahsers(n int, p uint64) []hasher {
 	// m is a max possible value you want for hash code + 1
 	// See: http://stackoverflow.com/questions/19701052/how-many-hash-functions-are-required-in-a-minhash-algorithm
 
-	hashers := make([]hasher, 0)
-	m := uint64(math.MaxUint32)
-
-	r := rand.New(rand.NewSource(31))
+	h := &hashers{
+		a: make([]uint64, n),
+		b: make([]uint64, n),
+		p: p,
+	}
 
 	for i := 0; i < n; i++ {
-		a := uint64(r.Int63n(int64(p)) + 1)
-		b := uint64(r.Int63n(int64(p)))
+		h.a[i] = uint64(r.Int63n(int64(p)) + 1)
+		h.b[i] = uint64(r.Int63n(int64(p)))
+	}
 
-		h := func(v ...uint32) uint32 {
-			var sum uint64
+	return h
+}
+
+// len returns the number of hash functions.
+func (h *hashers) len() int {
+	return len(h.a)
+}
 
-			// http://stackoverflow.com/questions/539311/generate-a-hash-sum-for-several-integers
-			for _, v := range v {
-				sum += a*uint64(v) + b
-			}
+// min updates each value of sig to the minimum of
+// itself and the ith hash function applied to x.
+func (h *hashers) min(sig vector, x uint32) {
+	a, b, p := h.a, h.b, h.p
+	v := uint64(x)
 
-			return uint32((sum % p) % m)
+	for i := range sig {
+		hash := uint32(((a[i]*v + b[i]) % p) % math.MaxUint32)
+		if hash < sig[i] {
+			sig[i] = hash
 		}
+	}
+}
+
+// sum returns the ith hash function applied to the sum of the values.
+func (h *hashers) sum(i int, v []uint32) uint32 {
+	var sum uint64
 
-		hashers = append(hashers, h)
+	// http://stackoverflow.com/questions/539311/generate-a-hash-sum-for-several-integers
+	for _, v := range v {
+		sum += h.a[i]*uint64(v) + h.b[i]
 	}
 
-	return hashers
+	return uint32((sum % h.p) % math.MaxUint32)
 }
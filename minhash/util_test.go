@@ -1,18 +1,12 @@
 package minhash
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestHashCode(t *testing.T) {
-	a := hashCode("some string")
-	b := hashCode("some string.")
-
-	assert.NotEqual(t, a, b, "hashCode should generate different hashes")
-}
-
 func TestJaccard(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -40,11 +34,15 @@ func TestJaccard(t *testing.T) {
 }
 
 func TestGenerateHashers(t *testing.T) {
-	hashers := generateHahsers(2, 7)
+	hashers := generateHashers(2, 7, rand.New(rand.NewSource(31)))
 
-	assert.Len(t, hashers, 2)
-	a := hashers[0](5)
-	b := hashers[1](5)
+	assert.Equal(t, 2, hashers.len())
+	a := hashers.sum(0, []uint32{5})
+	b := hashers.sum(1, []uint32{5})
 
 	assert.NotEqual(t, a, b)
+
+	sig := newSignature(2)
+	hashers.min(sig, 5)
+	assert.Equal(t, vector{a, b}, sig)
 }
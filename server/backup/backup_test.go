@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	src, err := ioutil.TempDir("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "config.json"), []byte("{}"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(src, "index"), 0744))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "index", "00000000.seg"), []byte("segment"), 0644))
+
+	var b bytes.Buffer
+	require.NoError(t, Write(&b, src))
+
+	dst, err := ioutil.TempDir("", "restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	require.NoError(t, Restore(bytes.NewReader(b.Bytes()), dst))
+
+	config, err := ioutil.ReadFile(filepath.Join(dst, "config.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(config))
+
+	segment, err := ioutil.ReadFile(filepath.Join(dst, "index", "00000000.seg"))
+	require.NoError(t, err)
+	assert.Equal(t, "segment", string(segment))
+
+	// an existing data directory is never overwritten
+	assert.Error(t, Restore(bytes.NewReader(b.Bytes()), dst))
+}
+
+func TestRestore_InvalidPath(t *testing.T) {
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	dst, err := ioutil.TempDir("", "restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	assert.Error(t, Restore(&b, filepath.Join(dst, "data")))
+
+	_, err = os.Stat(filepath.Join(dst, "escape"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,14 +1,87 @@
 package command
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/goraft/raft"
 	"github.com/mauidude/deduper/minhash"
 )
 
+// index is the part of the server's index used by commands.
+type index interface {
+	Add(id string, r io.Reader) error
+	AddHashed(id string, hashed []byte) error
+}
+
+// durableIndex is implemented by indexes which store their documents
+// on disk. They are given the index of each log entry so entries which
+// were stored before a restart are skipped when the log is replayed.
+type durableIndex interface {
+	AddAt(id string, r io.Reader, index uint64) error
+	AddHashedAt(id string, hashed []byte, stamp minhash.Stamp, index uint64) error
+}
+
+// expiringIndex is implemented by indexes whose documents can expire.
+type expiringIndex interface {
+	ExpireAt(before time.Time, index uint64) int
+}
+
+// evictingIndex is implemented by indexes which can evict documents.
+type evictingIndex interface {
+	EvictAt(max int, ids []string, index uint64) int
+}
+
+// Command is a command which can be applied to the
+// index without a Raft server.
+type Command interface {
+	raft.Command
+
+	// ApplyTo applies the command to the index as the
+	// entry of the log with the given index.
+	ApplyTo(idx interface{}, entry uint64) error
+}
+
+// Decode decodes the command with the given name.
+func Decode(name string, b []byte) (Command, error) {
+	var c Command
+	switch name {
+	case "write":
+		c = &WriteCommand{}
+	case "add":
+		c = &AddCommand{}
+	case "import":
+		c = &ImportCommand{}
+	case "expire":
+		c = &ExpireCommand{}
+	case "evict":
+		c = &EvictCommand{}
+	default:
+		return nil, fmt.Errorf("unknown command %q", name)
+	}
+
+	return c, json.Unmarshal(b, c)
+}
+
+// entryIndex returns the index of the log entry being applied.
+func entryIndex(c raft.Context) uint64 {
+	// entries are applied as the commit index advances, or as the log
+	// is read when it is opened, before the commit index is restored
+	if c.CurrentIndex() < c.CommitIndex() {
+		return c.CurrentIndex()
+	}
+
+	return c.CommitIndex()
+}
+
 // WriteCommand represents a command to persist a
 // document ID and it's generated minhash value.
+//
+// Deprecated: WriteCommand replicates the whole text of the document. It
+// is only applied when replaying logs written before AddCommand existed.
 type WriteCommand struct {
 	// ID is the document id
 	ID string `json:"id"`
@@ -31,8 +104,213 @@ func (c *WriteCommand) CommandName() string {
 }
 
 // Apply writes a value to a key.
-func (c *WriteCommand) Apply(server raft.Server) (interface{}, error) {
-	mh := server.Context().(*minhash.MinHasher)
-	mh.Add(c.ID, strings.NewReader(c.Value))
-	return nil, nil
+func (c *WriteCommand) Apply(ctx raft.Context) (interface{}, error) {
+	return nil, c.ApplyTo(ctx.Server().Context(), entryIndex(ctx))
+}
+
+// ApplyTo writes a value to a key of the index.
+func (c *WriteCommand) ApplyTo(idx interface{}, entry uint64) error {
+	if d, ok := idx.(durableIndex); ok {
+		return d.AddAt(c.ID, strings.NewReader(c.Value), entry)
+	}
+
+	return idx.(index).Add(c.ID, strings.NewReader(c.Value))
+}
+
+// AddCommand represents a command to add a document which
+// was hashed by the leader. Only the hashes are replicated,
+// not the text of the document.
+type AddCommand struct {
+	// ID is the document id
+	ID string `json:"id"`
+
+	// Hashed is the document hashed by the index
+	Hashed []byte `json:"hashed"`
+
+	// Added is when the leader added the document, in Unix nanoseconds
+	Added int64 `json:"added,omitempty"`
+
+	// Expires is when the document expires, in Unix nanoseconds,
+	// or zero if it never does
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// NewAddCommand creates a new add command.
+func NewAddCommand(id string, hashed []byte, stamp minhash.Stamp) *AddCommand {
+	c := &AddCommand{
+		ID:     id,
+		Hashed: hashed,
+	}
+
+	if !stamp.Added.IsZero() {
+		c.Added = stamp.Added.UnixNano()
+	}
+
+	if !stamp.Expires.IsZero() {
+		c.Expires = stamp.Expires.UnixNano()
+	}
+
+	return c
+}
+
+// stamp returns when the document was added and expires.
+func (c *AddCommand) stamp() minhash.Stamp {
+	var stamp minhash.Stamp
+	if c.Added != 0 {
+		stamp.Added = time.Unix(0, c.Added).UTC()
+	}
+
+	if c.Expires != 0 {
+		stamp.Expires = time.Unix(0, c.Expires).UTC()
+	}
+
+	return stamp
+}
+
+// CommandName returns the name of the command.
+func (c *AddCommand) CommandName() string {
+	return "add"
+}
+
+// Apply adds the hashed document to the index.
+func (c *AddCommand) Apply(ctx raft.Context) (interface{}, error) {
+	return nil, c.ApplyTo(ctx.Server().Context(), entryIndex(ctx))
+}
+
+// ApplyTo adds the hashed document to the index.
+func (c *AddCommand) ApplyTo(idx interface{}, entry uint64) error {
+	if d, ok := idx.(durableIndex); ok {
+		return d.AddHashedAt(c.ID, c.Hashed, c.stamp(), entry)
+	}
+
+	return idx.(index).AddHashed(c.ID, c.Hashed)
+}
+
+// batchIndex is implemented by durable indexes which can add
+// several documents as a single entry of the log.
+type batchIndex interface {
+	AddHashedBatchAt(ids []string, hashed [][]byte, stamps []minhash.Stamp, index uint64) error
+}
+
+// ImportCommand represents a command to add a batch of
+// hashed documents, such as those of an export.
+type ImportCommand struct {
+	// Documents are the documents to add
+	Documents []*AddCommand `json:"documents"`
+}
+
+// NewImportCommand creates a new import command.
+func NewImportCommand(documents []*AddCommand) *ImportCommand {
+	return &ImportCommand{
+		Documents: documents,
+	}
+}
+
+// CommandName returns the name of the command.
+func (c *ImportCommand) CommandName() string {
+	return "import"
+}
+
+// Apply adds the hashed documents to the index.
+func (c *ImportCommand) Apply(ctx raft.Context) (interface{}, error) {
+	return nil, c.ApplyTo(ctx.Server().Context(), entryIndex(ctx))
+}
+
+// ApplyTo adds the hashed documents to the index.
+func (c *ImportCommand) ApplyTo(idx interface{}, entry uint64) error {
+	if b, ok := idx.(batchIndex); ok {
+		ids := make([]string, len(c.Documents))
+		hashed := make([][]byte, len(c.Documents))
+		stamps := make([]minhash.Stamp, len(c.Documents))
+		for i, d := range c.Documents {
+			ids[i] = d.ID
+			hashed[i] = d.Hashed
+			stamps[i] = d.stamp()
+		}
+
+		return b.AddHashedBatchAt(ids, hashed, stamps, entry)
+	}
+
+	for _, d := range c.Documents {
+		if err := idx.(index).AddHashed(d.ID, d.Hashed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExpireCommand represents a command to remove the documents which
+// expired before a time decided by the leader, so every server
+// removes the same documents.
+type ExpireCommand struct {
+	// Before is the time documents expire at or before,
+	// in Unix nanoseconds
+	Before int64 `json:"before"`
+}
+
+// NewExpireCommand creates a new expire command.
+func NewExpireCommand(before time.Time) *ExpireCommand {
+	return &ExpireCommand{
+		Before: before.UnixNano(),
+	}
+}
+
+// CommandName returns the name of the command.
+func (c *ExpireCommand) CommandName() string {
+	return "expire"
+}
+
+// Apply removes the expired documents from the index.
+func (c *ExpireCommand) Apply(ctx raft.Context) (interface{}, error) {
+	return nil, c.ApplyTo(ctx.Server().Context(), entryIndex(ctx))
+}
+
+// ApplyTo removes the expired documents from the index.
+// Indexes whose documents cannot expire are left as they are.
+func (c *ExpireCommand) ApplyTo(idx interface{}, entry uint64) error {
+	if e, ok := idx.(expiringIndex); ok {
+		e.ExpireAt(time.Unix(0, c.Before), entry)
+	}
+
+	return nil
+}
+
+// EvictCommand represents a command to evict documents from a full
+// index. The documents are chosen by the leader, so every server
+// evicts the same documents.
+type EvictCommand struct {
+	// Max is the number of documents kept
+	Max int `json:"max"`
+
+	// IDs are the ids of the documents evicted first, before the oldest
+	IDs []string `json:"ids,omitempty"`
+}
+
+// NewEvictCommand creates a new evict command.
+func NewEvictCommand(max int, ids []string) *EvictCommand {
+	return &EvictCommand{
+		Max: max,
+		IDs: ids,
+	}
+}
+
+// CommandName returns the name of the command.
+func (c *EvictCommand) CommandName() string {
+	return "evict"
+}
+
+// Apply evicts documents from the index.
+func (c *EvictCommand) Apply(ctx raft.Context) (interface{}, error) {
+	return nil, c.ApplyTo(ctx.Server().Context(), entryIndex(ctx))
+}
+
+// ApplyTo evicts documents from the index. Indexes which
+// cannot evict documents are left as they are.
+func (c *EvictCommand) ApplyTo(idx interface{}, entry uint64) error {
+	if e, ok := idx.(evictingIndex); ok {
+		e.EvictAt(c.Max, c.IDs, entry)
+	}
+
+	return nil
 }
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminTokenHeader is the header holding the shared secret of requests
+// to the admin routes.
+const AdminTokenHeader = "X-Admin-Token"
+
+// Admin middleware only lets requests to the admin routes, whose paths
+// start with Prefix, through when they hold the shared secret in the
+// AdminTokenHeader header.
+type Admin struct {
+	// Prefix is the path prefix of the admin routes.
+	Prefix string
+
+	// Token is the shared secret. The admin routes are disabled
+	// if it is empty.
+	Token string
+}
+
+// ServeHTTP rejects requests to the admin routes without the token.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !strings.HasPrefix(r.URL.Path, a.Prefix) {
+		next(w, r)
+		return
+	}
+
+	if a.Token == "" {
+		writeError(w, http.StatusForbidden, "no admin token is configured")
+		return
+	}
+
+	token := r.Header.Get(AdminTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+
+	next(w, r)
+}
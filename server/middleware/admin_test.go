@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin(t *testing.T) {
+	cases := []struct {
+		token  string
+		path   string
+		header string
+		code   int
+	}{
+		{"secret", "/documents/1", "", http.StatusOK},
+		{"secret", "/admin/config", "secret", http.StatusOK},
+		{"secret", "/admin/config", "", http.StatusUnauthorized},
+		{"secret", "/admin/backup", "other", http.StatusUnauthorized},
+		{"", "/admin/config", "", http.StatusForbidden},
+		{"", "/documents/1", "", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		a := &Admin{Prefix: "/admin/", Token: c.token}
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", c.path, nil)
+		if c.header != "" {
+			r.Header.Set(AdminTokenHeader, c.header)
+		}
+
+		next := &mockHandler{}
+		a.ServeHTTP(rw, r, next.ServeHTTP)
+
+		assert.Equal(t, c.code, rw.Code, c.path)
+		assert.Equal(t, c.code == http.StatusOK, next.called, c.path)
+	}
+}
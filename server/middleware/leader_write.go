@@ -1,13 +1,54 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/goraft/raft"
 	"github.com/gorilla/mux"
 )
 
+const (
+	// DefaultTimeout is how long a write forwarded to the leader
+	// may take before it fails with a 504 response.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultRetryAfter is how long clients are asked to wait before
+	// retrying a write when no leader is known.
+	DefaultRetryAfter = time.Second
+)
+
+// Forwarding is how a follower forwards writes to the leader.
+type Forwarding int
+
+const (
+	// Proxy sends writes to the leader and returns its responses.
+	Proxy Forwarding = iota
+
+	// Redirect answers writes with a 307 Temporary Redirect to the
+	// leader, so clients send the body to the leader themselves.
+	Redirect
+)
+
+// hopHeaders are the hop-by-hop headers, which apply to a single
+// connection and are not forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
 type RaftServer interface {
 	Leader() string
 	Name() string
@@ -19,6 +60,13 @@ type RaftServer interface {
 type LeaderWrite struct {
 	Client *http.Client
 
+	// Forwarding is how writes are forwarded. The default is Proxy.
+	Forwarding Forwarding
+
+	// RetryAfter is the value of the Retry-After header of the 503
+	// response returned when no leader is known.
+	RetryAfter time.Duration
+
 	raftServer RaftServer
 	routes     []*mux.Route
 }
@@ -26,16 +74,22 @@ type LeaderWrite struct {
 // NewLeadWrite creates a LeaderWrite. Provide any routes you want
 // forwarded to the leader in the routes parameter. All redirected
 // requests will append a `X-Follower-Redirect-For` header with the
-// name of the Raft server that initiated the redirect.
+// name of the Raft server that initiated the redirect. Requests with
+// the header are never forwarded again, so servers which disagree
+// about the leader cannot forward a request in a loop.
 func NewLeadWrite(r RaftServer, routes ...*mux.Route) *LeaderWrite {
 	return &LeaderWrite{
-		Client:     http.DefaultClient,
+		Client:     &http.Client{Timeout: DefaultTimeout},
+		RetryAfter: DefaultRetryAfter,
 		raftServer: r,
 		routes:     routes,
 	}
 }
 
-// ServeHTTP proxies requests to the leader.
+// ServeHTTP proxies or redirects requests to the leader. The status code,
+// headers and body of the leader's response to a proxied request are
+// returned to the client. Redirects have an `X-Raft-Leader` header with
+// the connection string of the leader.
 func (l *LeaderWrite) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	if !l.matches(r) {
 		next(w, r)
@@ -43,31 +97,107 @@ func (l *LeaderWrite) ServeHTTP(w http.ResponseWriter, r *http.Request, next htt
 	}
 
 	leader := l.raftServer.Leader()
-	// if not leader
-	if leader != l.raftServer.Name() {
-		connString := l.raftServer.Peers()[leader].ConnectionString
+	if leader == l.raftServer.Name() {
+		next(w, r)
+		return
+	}
 
-		request, _ := http.NewRequest(r.Method, fmt.Sprintf("%s%s", connString, r.URL.Path), r.Body)
-		defer r.Body.Close()
+	peer, ok := l.raftServer.Peers()[leader]
+	if leader == "" || !ok {
+		// an election is in progress, or this server
+		// has yet to hear from the new leader
+		w.Header().Set("Retry-After", l.retryAfter())
+		writeError(w, http.StatusServiceUnavailable, "no leader is known")
+		return
+	}
 
-		// copy headers
-		for k, vals := range r.Header {
-			for _, v := range vals {
-				request.Header.Add(k, v)
-			}
+	if from := r.Header.Get("X-Follower-Redirect-For"); from != "" {
+		// the follower which forwarded the request thinks this server
+		// is the leader, which happens until an election settles
+		w.Header().Set("Retry-After", l.retryAfter())
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("the request was forwarded by %s, but %s is not the leader", from, l.raftServer.Name()))
+		return
+	}
+
+	// the query string holds the options of the write
+	url := peer.ConnectionString + r.URL.RequestURI()
+
+	if l.Forwarding == Redirect {
+		w.Header().Set("X-Raft-Leader", peer.ConnectionString)
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+
+	defer r.Body.Close()
+
+	request, err := http.NewRequest(r.Method, url, r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	request = request.WithContext(r.Context())
+
+	// the body is not a type whose length http.NewRequest knows,
+	// keep the client's length so the write is not sent chunked
+	request.ContentLength = r.ContentLength
+
+	// copy headers
+	for k, vals := range r.Header {
+		for _, v := range vals {
+			request.Header.Add(k, v)
 		}
+	}
+	removeHopHeaders(request.Header)
 
-		request.Header.Add("X-Follower-Redirect-For", l.raftServer.Name())
+	request.Header.Add("X-Follower-Redirect-For", l.raftServer.Name())
 
-		_, err := l.Client.Do(request)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	resp, err := l.Client.Do(request)
+	if err != nil {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			writeError(w, http.StatusGatewayTimeout, "the leader did not respond in time")
+		} else {
+			writeError(w, http.StatusBadGateway, err.Error())
 		}
 
 		return
 	}
+	defer resp.Body.Close()
+
+	// the leader's headers replace those set by earlier middleware
+	removeHopHeaders(resp.Header)
+	for k, vals := range resp.Header {
+		w.Header()[k] = vals
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// retryAfter returns the value of the Retry-After header, in whole
+// seconds rounded up, so clients always wait.
+func (l *LeaderWrite) retryAfter() string {
+	seconds := int((l.RetryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
 
-	next(w, r)
+	return strconv.Itoa(seconds)
+}
+
+// removeHopHeaders removes the hop-by-hop headers, and those
+// listed in the Connection header, as a proxy must.
+func removeHopHeaders(h http.Header) {
+	for _, vals := range h["Connection"] {
+		for _, name := range strings.Split(vals, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
 }
 
 func (l *LeaderWrite) matches(r *http.Request) bool {
@@ -80,3 +210,11 @@ func (l *LeaderWrite) matches(r *http.Request) bool {
 
 	return false
 }
+
+// writeError writes an error response in the format of the server's errors.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string][]string{
+		"errors": []string{message},
+	})
+}
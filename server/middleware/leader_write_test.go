@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/goraft/raft"
 	"github.com/gorilla/mux"
@@ -18,7 +19,7 @@ import (
 
 func TestLeaderWrite_Follower(t *testing.T) {
 	// start "leader"
-	leaderHandler := &mockHandler{}
+	leaderHandler := &mockHandler{code: http.StatusBadRequest, reply: `{"errors":["invalid"]}`}
 	leaderListener, err := net.Listen("tcp", ":0")
 	require.NoError(t, err)
 
@@ -28,7 +29,7 @@ func TestLeaderWrite_Follower(t *testing.T) {
 	leaderName := "finn_the_human"
 
 	body := strings.NewReader("hey girl, hey")
-	r, _ := http.NewRequest("POST", "/forward", body)
+	r, _ := http.NewRequest("POST", "/forward?ttl=1h&tag=a%20b", body)
 	r.Header.Add("Some-Header", "Some-Value")
 	rw := httptest.NewRecorder()
 	raftServer := &mockRaftServer{
@@ -48,14 +49,91 @@ func TestLeaderWrite_Follower(t *testing.T) {
 	next := &mockHandler{}
 	lw.ServeHTTP(rw, r, next.ServeHTTP)
 
-	assert.Equal(t, http.StatusOK, rw.Code)
+	// the leader's response is returned
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, `{"errors":["invalid"]}`, rw.Body.String())
+	assert.Equal(t, "leader", rw.Header().Get("X-Handled-By"))
 	assert.Equal(t, "hey girl, hey", leaderHandler.body.String())
+	assert.Equal(t, "/forward?ttl=1h&tag=a%20b", leaderHandler.r.URL.RequestURI())
+
+	// the body is sent with its length, not chunked
+	assert.Equal(t, int64(len("hey girl, hey")), leaderHandler.r.ContentLength)
+	assert.Empty(t, leaderHandler.r.TransferEncoding)
 
 	assert.False(t, next.called)
 	assert.Equal(t, "Some-Value", leaderHandler.r.Header.Get("Some-Header"))
 	assert.Equal(t, followerName, leaderHandler.r.Header.Get("X-Follower-Redirect-For"))
 }
 
+func TestLeaderWrite_HopHeaders(t *testing.T) {
+	// start a "leader" which replies with hop-by-hop headers
+	leaderHandler := &mockHandler{code: http.StatusOK, header: http.Header{
+		"Connection":         {"X-Private"},
+		"X-Private":          {"leader"},
+		"Proxy-Authenticate": {"Basic"},
+		"Upgrade":            {"h2c"},
+	}}
+	leaderListener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer leaderListener.Close()
+
+	go http.Serve(leaderListener, leaderHandler)
+
+	r, _ := http.NewRequest("POST", "/forward", strings.NewReader("body"))
+	r.Header.Add("Connection", "X-Secret, Keep-Alive")
+	r.Header.Add("X-Secret", "follower")
+	r.Header.Add("Keep-Alive", "timeout=5")
+	r.Header.Add("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	r.Header.Add("Te", "trailers")
+	r.Header.Add("Some-Header", "Some-Value")
+	rw := httptest.NewRecorder()
+	raftServer := &mockRaftServer{
+		name:   "jake_the_dog",
+		leader: "finn_the_human",
+		peers: map[string]*raft.Peer{
+			"finn_the_human": &raft.Peer{
+				ConnectionString: fmt.Sprintf("http://localhost:%d", leaderListener.Addr().(*net.TCPAddr).Port),
+			},
+		},
+	}
+
+	handler := &mockHandler{}
+	route := mux.NewRouter().HandleFunc("/forward", handler.ServeHTTP).Methods("POST")
+	lw := NewLeadWrite(raftServer, route)
+
+	next := &mockHandler{}
+	lw.ServeHTTP(rw, r, next.ServeHTTP)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	// the follower's hop-by-hop headers are not sent to the leader
+	for _, name := range []string{"X-Secret", "Keep-Alive", "Proxy-Authorization", "Te"} {
+		assert.Empty(t, leaderHandler.r.Header.Get(name), name)
+	}
+	assert.Equal(t, "Some-Value", leaderHandler.r.Header.Get("Some-Header"))
+
+	// nor are the leader's returned to the client
+	for _, name := range []string{"Connection", "X-Private", "Proxy-Authenticate", "Upgrade"} {
+		assert.Empty(t, rw.Header().Get(name), name)
+	}
+	assert.Equal(t, "leader", rw.Header().Get("X-Handled-By"))
+}
+
+func TestLeaderWrite_RetryAfter(t *testing.T) {
+	lw := NewLeadWrite(&mockRaftServer{})
+
+	for retryAfter, expected := range map[time.Duration]string{
+		0:                       "1",
+		500 * time.Millisecond:  "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		time.Minute:             "60",
+	} {
+		lw.RetryAfter = retryAfter
+		assert.Equal(t, expected, lw.retryAfter(), retryAfter.String())
+	}
+}
+
 func TestLeaderWrite_Leader(t *testing.T) {
 	// start "leader"
 	leaderHandler := &mockHandler{}
@@ -115,11 +193,163 @@ func TestLeaderWrite_Follower_NoForward(t *testing.T) {
 	assert.False(t, leaderHandler.called)
 }
 
+func TestLeaderWrite_Redirect(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/forward?ttl=1h", strings.NewReader("hey girl, hey"))
+	rw := httptest.NewRecorder()
+	raftServer := &mockRaftServer{
+		name:   "jake_the_dog",
+		leader: "finn_the_human",
+		peers: map[string]*raft.Peer{
+			"finn_the_human": &raft.Peer{
+				ConnectionString: "http://leader:4001",
+			},
+		},
+	}
+
+	handler := &mockHandler{}
+	route := mux.NewRouter().HandleFunc("/forward", handler.ServeHTTP).Methods("POST")
+	lw := NewLeadWrite(raftServer, route)
+	lw.Forwarding = Redirect
+
+	next := &mockHandler{}
+	lw.ServeHTTP(rw, r, next.ServeHTTP)
+
+	assert.Equal(t, http.StatusTemporaryRedirect, rw.Code)
+	assert.Equal(t, "http://leader:4001/forward?ttl=1h", rw.Header().Get("Location"))
+	assert.Equal(t, "http://leader:4001", rw.Header().Get("X-Raft-Leader"))
+	assert.False(t, next.called)
+
+	// the leader handles redirected writes itself
+	raftServer.name = "finn_the_human"
+	r, _ = http.NewRequest("POST", "/forward", strings.NewReader("hey girl, hey"))
+	rw = httptest.NewRecorder()
+	lw.ServeHTTP(rw, r, next.ServeHTTP)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, next.called)
+}
+
+func TestLeaderWrite_Loop(t *testing.T) {
+	// start a "leader" which would accept the request
+	leaderHandler := &mockHandler{}
+	leaderListener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer leaderListener.Close()
+
+	go http.Serve(leaderListener, leaderHandler)
+
+	// the request was forwarded by a follower which thinks this server is the leader
+	r, _ := http.NewRequest("POST", "/forward", strings.NewReader("hey girl, hey"))
+	r.Header.Add("X-Follower-Redirect-For", "finn_the_human")
+	rw := httptest.NewRecorder()
+	raftServer := &mockRaftServer{
+		name:   "jake_the_dog",
+		leader: "finn_the_human",
+		peers: map[string]*raft.Peer{
+			"finn_the_human": &raft.Peer{
+				ConnectionString: fmt.Sprintf("http://localhost:%d", leaderListener.Addr().(*net.TCPAddr).Port),
+			},
+		},
+	}
+
+	handler := &mockHandler{}
+	route := mux.NewRouter().HandleFunc("/forward", handler.ServeHTTP).Methods("POST")
+
+	for _, forwarding := range []Forwarding{Proxy, Redirect} {
+		lw := NewLeadWrite(raftServer, route)
+		lw.Forwarding = forwarding
+
+		next := &mockHandler{}
+		lw.ServeHTTP(rw, r, next.ServeHTTP)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+		assert.Equal(t, "1", rw.Header().Get("Retry-After"))
+		assert.False(t, next.called)
+		assert.False(t, leaderHandler.called)
+
+		rw = httptest.NewRecorder()
+	}
+
+	// the leader accepts forwarded requests
+	raftServer.name = "finn_the_human"
+	lw := NewLeadWrite(raftServer, route)
+
+	next := &mockHandler{}
+	lw.ServeHTTP(rw, r, next.ServeHTTP)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, next.called)
+}
+
+func TestLeaderWrite_NoLeader(t *testing.T) {
+	for _, leader := range []string{"", "finn_the_human"} {
+		r, _ := http.NewRequest("POST", "/forward", strings.NewReader("body"))
+		rw := httptest.NewRecorder()
+
+		// the leader is unknown, or is not yet a peer
+		raftServer := &mockRaftServer{
+			name:   "jake_the_dog",
+			leader: leader,
+		}
+
+		handler := &mockHandler{}
+		route := mux.NewRouter().HandleFunc("/forward", handler.ServeHTTP).Methods("POST")
+		lw := NewLeadWrite(raftServer, route)
+
+		next := &mockHandler{}
+		lw.ServeHTTP(rw, r, next.ServeHTTP)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+		assert.Equal(t, "1", rw.Header().Get("Retry-After"))
+		assert.False(t, next.called)
+	}
+}
+
+func TestLeaderWrite_Timeout(t *testing.T) {
+	// start a "leader" which is too slow
+	leaderHandler := &mockHandler{delay: time.Second}
+	leaderListener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer leaderListener.Close()
+
+	go http.Serve(leaderListener, leaderHandler)
+
+	r, _ := http.NewRequest("POST", "/forward", strings.NewReader("body"))
+	rw := httptest.NewRecorder()
+	raftServer := &mockRaftServer{
+		name:   "jake_the_dog",
+		leader: "finn_the_human",
+		peers: map[string]*raft.Peer{
+			"finn_the_human": &raft.Peer{
+				ConnectionString: fmt.Sprintf("http://localhost:%d", leaderListener.Addr().(*net.TCPAddr).Port),
+			},
+		},
+	}
+
+	handler := &mockHandler{}
+	route := mux.NewRouter().HandleFunc("/forward", handler.ServeHTTP).Methods("POST")
+	lw := NewLeadWrite(raftServer, route)
+	lw.Client.Timeout = 50 * time.Millisecond
+
+	next := &mockHandler{}
+	lw.ServeHTTP(rw, r, next.ServeHTTP)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rw.Code)
+	assert.False(t, next.called)
+}
+
 type mockHandler struct {
 	called bool
 	rw     http.ResponseWriter
 	r      *http.Request
 	body   *bytes.Buffer
+
+	// the response written, if code is not zero,
+	// and how long it takes to write it
+	code   int
+	header http.Header
+	reply  string
+	delay  time.Duration
 }
 
 func (m *mockHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
@@ -133,6 +363,18 @@ func (m *mockHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		m.body = &bytes.Buffer{}
 		io.Copy(m.body, r.Body)
 	}
+
+	time.Sleep(m.delay)
+
+	if m.code != 0 {
+		for k, vals := range m.header {
+			rw.Header()[k] = vals
+		}
+
+		rw.Header().Set("X-Handled-By", "leader")
+		rw.WriteHeader(m.code)
+		io.WriteString(rw, m.reply)
+	}
 }
 
 type mockRaftServer struct {
@@ -3,22 +3,30 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/goraft/raft"
 	"github.com/gorilla/mux"
 	"github.com/mauidude/deduper/minhash"
+	"github.com/mauidude/deduper/server/backup"
 	"github.com/mauidude/deduper/server/command"
 	"github.com/mauidude/deduper/server/middleware"
+	"github.com/mauidude/deduper/server/wal"
+	"github.com/mauidude/deduper/text"
 )
 
 var (
@@ -27,25 +35,163 @@ var (
 	contentTypeJSON = "application/json"
 )
 
+// Index is a collection of documents which can be
+// searched for near duplicates.
+type Index interface {
+	// Add adds a document to the index.
+	Add(id string, r io.Reader) error
+
+	// Hash hashes a document so it can be replicated
+	// without its text and added with AddHashed.
+	Hash(r io.Reader) ([]byte, error)
+
+	// AddHashed adds a document hashed by Hash to the index.
+	AddHashed(id string, hashed []byte) error
+
+	// FindSimilar returns the documents whose similarity to the
+	// given document is at least threshold.
+	FindSimilar(r io.Reader, threshold float64) ([]minhash.Match, error)
+
+	// Contains returns true if the index contains the document.
+	Contains(id string) bool
+
+	// Config returns the config of the index.
+	Config() minhash.Config
+
+	// Stats returns the number of documents in the
+	// index and the memory they use.
+	Stats() minhash.Stats
+}
+
+// containmentIndex is an index which can also find documents by containment.
+type containmentIndex interface {
+	Find(r io.Reader, threshold float64, mode minhash.Mode) ([]minhash.Match, error)
+}
+
+// windowIndex is an index which can find the documents added in a window of time.
+type windowIndex interface {
+	FindIn(r io.Reader, threshold float64, mode minhash.Mode, w minhash.Window) ([]minhash.Match, error)
+}
+
+// passageIndex is an index which can also find similar passages.
+type passageIndex interface {
+	FindPassages(r io.Reader, threshold float64) ([]minhash.PassageMatch, error)
+}
+
+// explainIndex is an index which can explain the similarity of documents.
+type explainIndex interface {
+	Explain(id string, r io.Reader) (*minhash.Explanation, error)
+}
+
+// checkpointIndex is an index which can make the documents added to it durable.
+type checkpointIndex interface {
+	Checkpoint() error
+	Checkpointed() (uint64, error)
+}
+
+// snapshotIndex is an index which can write a snapshot of its documents.
+type snapshotIndex interface {
+	Snapshot(dir string) error
+}
+
+// expiringIndex is an index whose documents can expire.
+type expiringIndex interface {
+	Expiring(now time.Time) bool
+}
+
+// evictingIndex is an index which can evict documents when it is full.
+type evictingIndex interface {
+	Evictions() ([]string, bool)
+}
+
+// exportIndex is an index which can export its documents.
+type exportIndex interface {
+	Export(w io.Writer) error
+}
+
+// importBatchSize is the number of imported documents added by each command.
+const importBatchSize = 1000
+
+// raftInfo is the Raft metadata of a server saved in backups.
+type raftInfo struct {
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	Leader      string    `json:"leader,omitempty"`
+	Peers       []string  `json:"peers,omitempty"`
+	Term        uint64    `json:"term"`
+	CommitIndex uint64    `json:"commit_index"`
+	Created     time.Time `json:"created"`
+}
+
+// queryModes are the values of the mode parameter of similarity queries.
+var queryModes = map[string]minhash.Mode{
+	"jaccard":     minhash.ModeJaccard,
+	"containment": minhash.ModeContainment,
+	"contained":   minhash.ModeContained,
+}
+
 // Server provides an HTTP interface to the deduper.
 type Server struct {
+	// JSONField is the dot separated path of the field holding
+	// the text of JSON documents. If it is empty all the strings
+	// in the document are used.
+	JSONField string
+
+	// MaxBodySize is the largest document, in bytes, which is accepted.
+	// Larger documents are rejected with a 413 response. There is no
+	// limit if it is zero.
+	MaxBodySize int64
+
+	// Standalone runs the server as a single node without Raft. Writes
+	// are appended to a write-ahead log in the format of the Raft log,
+	// so the node can later be restarted as the leader of a cluster.
+	Standalone bool
+
+	// CheckpointInterval is how often a standalone server checkpoints
+	// its index, so fewer entries of the log are applied when it is
+	// restarted. It is not checkpointed if it is zero.
+	CheckpointInterval time.Duration
+
+	// ExpireInterval is how often the leader removes the documents which
+	// expired. They are removed through the log, so every server removes
+	// the same documents. Documents do not expire if it is zero.
+	ExpireInterval time.Duration
+
+	// ForwardTimeout is how long a write forwarded by a follower to the
+	// leader may take. The default is middleware.DefaultTimeout.
+	ForwardTimeout time.Duration
+
+	// Forwarding is how followers forward writes to the leader, either
+	// proxying them or redirecting the client. The default is to proxy.
+	Forwarding middleware.Forwarding
+
+	// BackupDir is the directory backups are written to when a backup is
+	// requested with a name. Backups are only streamed if it is empty.
+	BackupDir string
+
+	// AdminToken is the shared secret which requests to the /admin
+	// routes must hold in the middleware.AdminTokenHeader header.
+	// The /admin routes are disabled if it is empty.
+	AdminToken string
+
 	path       string
 	host       string
 	port       int
 	name       string
 	raftServer raft.Server
+	wal        *wal.Log
 	router     *mux.Router
-	minhasher  *minhash.MinHasher
+	index      Index
 }
 
 // New creates a new Server.
-func New(path string, host string, port int, mh *minhash.MinHasher) *Server {
+func New(path string, host string, port int, index Index) *Server {
 	s := &Server{
-		path:      path,
-		host:      host,
-		port:      port,
-		router:    mux.NewRouter(),
-		minhasher: mh,
+		path:   path,
+		host:   host,
+		port:   port,
+		router: mux.NewRouter(),
+		index:  index,
 	}
 
 	// Read existing name or generate a new one.
@@ -72,12 +218,146 @@ func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *h
 // connects to the given leader. If leader is an empty string
 // this server will be a leader.
 func (s *Server) ListenAndServe(leader string) error {
+	if s.Standalone {
+		if leader != "" {
+			return errors.New("a standalone server cannot join a leader")
+		}
+
+		if err := s.openLog(); err != nil {
+			return err
+		}
+	} else {
+		// the matches of followers are not replicated, so
+		// a new leader would evict different documents
+		if s.index.Config().Eviction == minhash.EvictLRU {
+			return errors.New("lru eviction is only supported by standalone servers")
+		}
+
+		s.startRaft(leader)
+	}
+
+	s.startExpiry()
+
+	Logger.Println("Initializing HTTP server")
+
+	s.router.HandleFunc("/documents/similar", s.similarHandler).Methods("POST")
+	s.router.HandleFunc("/health", s.healthHandler).Methods("GET")
+	s.router.HandleFunc("/config", s.configHandler).Methods("GET")
+	s.router.HandleFunc("/stats", s.statsHandler).Methods("GET")
+	s.router.HandleFunc("/admin/config", s.adminConfigHandler).Methods("GET")
+	s.router.HandleFunc("/admin/backup", s.backupHandler).Methods("POST")
+	s.router.HandleFunc("/documents/{id}/explain", s.explainHandler).Methods("POST")
+	s.router.HandleFunc("/admin/export", s.exportHandler).Methods("GET")
+	importRoute := s.router.HandleFunc("/documents/import", s.importHandler).Methods("POST")
+	route := s.router.HandleFunc("/documents/{id}", s.postHandler).Methods("POST")
+
+	// Initialize and start HTTP server.
+	httpServer := negroni.New()
+
+	httpServer.Use(&middleware.ContentType{Type: contentTypeJSON})
+	httpServer.Use(&middleware.Admin{Prefix: "/admin/", Token: s.AdminToken})
+
+	if !s.Standalone {
+		s.router.HandleFunc("/join", s.joinHandler).Methods("POST")
+		lw := middleware.NewLeadWrite(s.raftServer, route, importRoute)
+		lw.Forwarding = s.Forwarding
+		if s.ForwardTimeout > 0 {
+			lw.Client.Timeout = s.ForwardTimeout
+		}
+
+		httpServer.Use(lw)
+	}
+
+	httpServer.UseHandler(s.router)
+
+	Logger.Println("Listening at:", s.connectionString())
+
+	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), httpServer)
+}
+
+// openLog opens the write-ahead log of a standalone server, applying the
+// entries which are not in the index, and starts checkpointing the index.
+func (s *Server) openLog() error {
+	var err error
+	Logger.Printf("Opening write-ahead log: %s", s.path)
+
+	if s.wal, err = wal.Open(filepath.Join(s.path, "log"), s.index); err != nil {
+		return err
+	}
+
+	ci, ok := s.index.(checkpointIndex)
+	if !ok || s.CheckpointInterval <= 0 {
+		return nil
+	}
+
+	// the entries replayed are checkpointed at once, which also
+	// fails early if the index cannot be checkpointed at all
+	if err := s.checkpoint(ci); err == minhash.ErrCheckpointUnsupported {
+		return errors.New("indexes which store passages or shingles cannot be checkpointed, set the checkpoint interval to 0")
+	} else if err != nil {
+		return err
+	}
+
+	go func() {
+		for range time.Tick(s.CheckpointInterval) {
+			if err := s.checkpoint(ci); err != nil {
+				Logger.Printf("Unable to checkpoint index: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// checkpoint checkpoints the index and then drops the entries of the
+// write-ahead log which the checkpoint made durable.
+func (s *Server) checkpoint(ci checkpointIndex) error {
+	if err := ci.Checkpoint(); err != nil {
+		return err
+	}
+
+	applied, err := ci.Checkpointed()
+	if err != nil || applied == 0 {
+		return err
+	}
+
+	return s.wal.Compact(applied)
+}
+
+// startExpiry starts removing the documents which expired, when the
+// server is the leader, if the index supports it.
+func (s *Server) startExpiry() {
+	ei, ok := s.index.(expiringIndex)
+	if !ok || s.ExpireInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for now := range time.Tick(s.ExpireInterval) {
+			if !s.Standalone && s.raftServer.State() != raft.Leader {
+				continue
+			}
+
+			if !ei.Expiring(now) {
+				continue
+			}
+
+			if _, err := s.do(command.NewExpireCommand(now)); err != nil {
+				Logger.Printf("Unable to expire documents: %v", err)
+			}
+		}
+	}()
+}
+
+// startRaft starts the Raft server and connects to the given leader.
+// If leader is an empty string this server will be a leader.
+func (s *Server) startRaft(leader string) {
 	var err error
 	Logger.Printf("Initializing Raft Server: %s", s.path)
 
 	// Initialize and start Raft server.
 	transporter := raft.NewHTTPTransporter("/raft", 200*time.Millisecond)
-	s.raftServer, err = raft.NewServer(s.name, s.path, transporter, nil, s.minhasher, "")
+	s.raftServer, err = raft.NewServer(s.name, s.path, transporter, nil, s.index, "")
 	if err != nil {
 		Logger.Fatal(err)
 	}
@@ -113,25 +393,6 @@ func (s *Server) ListenAndServe(leader string) error {
 	} else {
 		Logger.Println("Recovered from log")
 	}
-
-	Logger.Println("Initializing HTTP server")
-
-	s.router.HandleFunc("/documents/similar", s.similarHandler).Methods("POST")
-	s.router.HandleFunc("/join", s.joinHandler).Methods("POST")
-	s.router.HandleFunc("/health", s.healthHandler).Methods("GET")
-	route := s.router.HandleFunc("/documents/{id}", s.postHandler).Methods("POST")
-
-	// Initialize and start HTTP server.
-	httpServer := negroni.New()
-
-	httpServer.Use(&middleware.ContentType{contentTypeJSON})
-	httpServer.Use(middleware.NewLeadWrite(s.raftServer, route))
-
-	httpServer.UseHandler(s.router)
-
-	Logger.Println("Listening at:", s.connectionString())
-
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), httpServer)
 }
 
 // Join joins to the leader of an existing cluster.
@@ -165,6 +426,16 @@ func (s *Server) healthHandler(w http.ResponseWriter, req *http.Request) {
 		State  string           `json:"state"`
 	}
 
+	if s.Standalone {
+		_ = json.NewEncoder(w).Encode(&health{
+			Name:   s.name,
+			Peers:  make(map[string]*peer),
+			Leader: s.name,
+			State:  "standalone",
+		})
+		return
+	}
+
 	h := &health{
 		Name:   s.raftServer.Name(),
 		Peers:  make(map[string]*peer),
@@ -183,6 +454,156 @@ func (s *Server) healthHandler(w http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(w).Encode(h)
 }
 
+// publicConfig is the index config without the seed of its hash
+// functions, which would let anyone craft colliding documents.
+type publicConfig struct {
+	minhash.Config
+	Seed *int64 `json:"seed,omitempty"`
+}
+
+func (s *Server) configHandler(w http.ResponseWriter, req *http.Request) {
+	_ = json.NewEncoder(w).Encode(publicConfig{Config: s.index.Config()})
+}
+
+func (s *Server) adminConfigHandler(w http.ResponseWriter, req *http.Request) {
+	_ = json.NewEncoder(w).Encode(s.index.Config())
+}
+
+func (s *Server) statsHandler(w http.ResponseWriter, req *http.Request) {
+	_ = json.NewEncoder(w).Encode(s.index.Stats())
+}
+
+func (s *Server) backupHandler(w http.ResponseWriter, req *http.Request) {
+	si, ok := s.index.(snapshotIndex)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "the index does not support backups")
+		return
+	}
+
+	var path string
+	if name := req.URL.Query().Get("name"); name != "" {
+		var err error
+		if path, err = s.backupPath(name); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	dir, err := ioutil.TempDir(s.path, "backup")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if err := s.snapshot(dir, si); err != nil {
+		code := http.StatusInternalServerError
+		if err == minhash.ErrSnapshotUnsupported {
+			code = http.StatusBadRequest
+		}
+
+		writeError(w, code, err.Error())
+		return
+	}
+
+	if path == "" {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="deduper-backup.tar.gz"`)
+
+		if err := backup.Write(w, dir); err != nil {
+			// the response has started, so the client sees a truncated archive
+			Logger.Printf("Unable to write backup: %v", err)
+		}
+
+		return
+	}
+
+	if err := writeArchive(path, dir); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"path": path})
+}
+
+// backupPath returns the path of the backup with the given name in the
+// backup directory. The name must be a plain file name, so backups
+// cannot be written anywhere else.
+func (s *Server) backupPath(name string) (string, error) {
+	if s.BackupDir == "" {
+		return "", errors.New("no backup directory is configured")
+	}
+
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+
+	return filepath.Join(s.BackupDir, name), nil
+}
+
+// snapshot writes a consistent snapshot of the index, its config and the
+// Raft metadata of the server to dir, in the layout of a data directory.
+func (s *Server) snapshot(dir string, si snapshotIndex) error {
+	info := &raftInfo{
+		Name:    s.name,
+		State:   "standalone",
+		Created: time.Now().UTC(),
+	}
+
+	if !s.Standalone {
+		info.State = s.raftServer.State()
+		info.Leader = s.raftServer.Leader()
+		info.Term = s.raftServer.Term()
+		info.CommitIndex = s.raftServer.CommitIndex()
+
+		for name := range s.raftServer.Peers() {
+			info.Peers = append(info.Peers, name)
+		}
+
+		sort.Strings(info.Peers)
+	}
+
+	if err := si.Snapshot(filepath.Join(dir, "index")); err != nil {
+		return err
+	}
+
+	c := s.index.Config()
+	if err := minhash.WriteConfig(filepath.Join(dir, "config.json"), &c); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "raft.json"), b, 0644)
+}
+
+// writeArchive writes an archive of dir to path, replacing it atomically.
+func writeArchive(path string, dir string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err = backup.Write(f, dir); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
 func (s *Server) joinHandler(w http.ResponseWriter, req *http.Request) {
 	command := &raft.DefaultJoinCommand{}
 
@@ -205,39 +626,310 @@ func (s *Server) similarHandler(w http.ResponseWriter, req *http.Request) {
 	if t != "" {
 		var err error
 		if threshold, err = strconv.ParseFloat(t, 64); err != nil {
-			http.Error(w, `{"errors":["threshold is not a valid float"]}`, http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "threshold is not a valid float")
 			return
 		}
 	}
 
 	if threshold > 1.0 || threshold < 0 {
-		http.Error(w, `{"errors":["threshold must be between 0 and 1.0 inclusively"]}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "threshold must be between 0 and 1.0 inclusively")
+		return
+	}
+
+	now := time.Now()
+
+	since, err := parseTime(req.URL.Query().Get("since"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "since must be a time or a duration")
+		return
+	}
+
+	until, err := parseTime(req.URL.Query().Get("until"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "until must be a time or a duration")
 		return
 	}
 
-	matches := s.minhasher.FindSimilar(req.Body, threshold)
+	window := minhash.Window{Since: since, Until: until}
+
+	windowed := !window.Since.IsZero() || !window.Until.IsZero()
+
+	if req.URL.Query().Get("passages") == "true" {
+		if windowed {
+			writeError(w, http.StatusBadRequest, "passage queries do not support since and until")
+			return
+		}
+
+		s.passagesHandler(w, req, threshold)
+		return
+	}
+
+	mode := minhash.ModeJaccard
+	if name := req.URL.Query().Get("mode"); name != "" {
+		var ok bool
+		if mode, ok = queryModes[name]; !ok {
+			writeError(w, http.StatusBadRequest, "mode must be jaccard, containment or contained")
+			return
+		}
+	}
+
+	var matches []minhash.Match
+	if wi, ok := s.index.(windowIndex); ok && windowed {
+		matches, err = wi.FindIn(s.content(w, req), threshold, mode, window)
+	} else if windowed {
+		writeError(w, http.StatusBadRequest, "the index does not support since and until")
+		return
+	} else if mode == minhash.ModeJaccard {
+		matches, err = s.index.FindSimilar(s.content(w, req), threshold)
+	} else if ci, ok := s.index.(containmentIndex); ok {
+		matches, err = ci.Find(s.content(w, req), threshold, mode)
+	} else {
+		writeError(w, http.StatusBadRequest, "the index does not support containment queries")
+		return
+	}
+
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
 
 	_ = json.NewEncoder(w).Encode(matches)
 }
 
+func (s *Server) passagesHandler(w http.ResponseWriter, req *http.Request, threshold float64) {
+	pi, ok := s.index.(passageIndex)
+	if !ok {
+		writeError(w, http.StatusBadRequest, minhash.ErrPassagesDisabled.Error())
+		return
+	}
+
+	matches, err := pi.FindPassages(s.content(w, req), threshold)
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(matches)
+}
+
+func (s *Server) explainHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	ei, ok := s.index.(explainIndex)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "the index does not support explanations")
+		return
+	}
+
+	e, err := ei.Explain(mux.Vars(req)["id"], s.content(w, req))
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(e)
+}
+
 func (s *Server) postHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
 	vars := mux.Vars(req)
 
-	// Read the value from the POST body.
-	b, err := ioutil.ReadAll(req.Body)
+	c := s.index.Config()
+	ttl := c.TimeToLive()
+	if v := req.URL.Query().Get("ttl"); v != "" {
+		if _, ok := s.index.(expiringIndex); !ok {
+			writeError(w, http.StatusBadRequest, "the index does not support expiry")
+			return
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "ttl must be a positive duration")
+			return
+		}
+
+		ttl = d
+	}
+
+	// Hash the document as it is read so only the hashes are replicated.
+	hashed, err := s.index.Hash(s.content(w, req))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, errorStatus(err), err.Error())
 		return
 	}
-	defer req.Body.Close()
 
-	value := string(b)
+	// The leader stamps the document so every server expires it together.
+	stamp := minhash.NewStamp(time.Now(), ttl)
 
 	// Execute the command against the Raft server.
-	_, err = s.raftServer.Do(command.NewWriteCommand(vars["id"], value))
+	_, err = s.do(command.NewAddCommand(vars["id"], hashed, stamp))
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	s.evict()
+}
+
+// evict evicts documents through the log if the index holds more than
+// its maximum number of documents. Only the leader handles writes, so
+// it decides which documents every server evicts.
+func (s *Server) evict() {
+	ei, ok := s.index.(evictingIndex)
+	if !ok {
+		return
+	}
+
+	ids, ok := ei.Evictions()
+	if !ok {
+		return
+	}
+
+	c := s.index.Config()
+	if _, err := s.do(command.NewEvictCommand(c.MaxDocuments, ids)); err != nil {
+		Logger.Printf("Unable to evict documents: %v", err)
+	}
+}
+
+// do executes a command against the Raft server or, if the
+// server is standalone, appends it to the write-ahead log.
+func (s *Server) do(c command.Command) (interface{}, error) {
+	if s.Standalone {
+		return s.wal.Do(c)
+	}
+
+	return s.raftServer.Do(c)
+}
+
+func (s *Server) exportHandler(w http.ResponseWriter, req *http.Request) {
+	ei, ok := s.index.(exportIndex)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "the index does not support exports")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := ei.Export(w); err != nil {
+		// the response has started, so the client sees a truncated export
+		Logger.Printf("Unable to export documents: %v", err)
+	}
+}
+
+func (s *Server) importHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	r, err := minhash.NewExportReader(req.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c := s.index.Config()
+	if err := c.Compatible(&r.Header.Config); err != nil {
+		writeError(w, http.StatusBadRequest, "the export does not match the index: "+err.Error())
+		return
+	}
+
+	imported := 0
+	batch := make([]*command.AddCommand, 0, importBatchSize)
+
+	for {
+		record, err := r.Next()
+		if err == nil {
+			batch = append(batch, command.NewAddCommand(record.ID, record.Document, record.Stamp()))
+			if len(batch) < importBatchSize {
+				continue
+			}
+		} else if err != io.EOF {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%v, %d documents were imported", err, imported))
+			return
+		}
+
+		// add the batch through the log
+		if len(batch) > 0 {
+			if _, err := s.do(command.NewImportCommand(batch)); err != nil {
+				writeError(w, errorStatus(err), fmt.Sprintf("%v, %d documents were imported", err, imported))
+				return
+			}
+
+			imported += len(batch)
+			batch = make([]*command.AddCommand, 0, importBatchSize)
+			s.evict()
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]int{"imported": imported})
+}
+
+// parseTime parses a time in RFC 3339 format, or a duration before
+// now such as "1h". It returns the zero time if v is empty.
+func parseTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
 	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return now.Add(-d), nil
+}
+
+// errorStatus returns the HTTP status code for an error
+// returned by the index.
+func errorStatus(err error) int {
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	switch err {
+	case minhash.ErrEmptyDocument, minhash.ErrDocumentTooShort:
+		return http.StatusUnprocessableEntity
+	case minhash.ErrDocumentNotFound:
+		return http.StatusNotFound
+	}
+
+	return http.StatusBadRequest
+}
+
+// writeError writes an error response with the given status code and message.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string][]string{
+		"errors": []string{message},
+	})
+}
+
+// content returns a reader of the text of the request body, with any
+// markup removed based on the request's content type. Reading more than
+// MaxBodySize bytes of the body fails.
+func (s *Server) content(w http.ResponseWriter, req *http.Request) io.Reader {
+	if s.MaxBodySize > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, s.MaxBodySize)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		return text.NewHTMLReader(req.Body)
+	case "text/markdown", "text/x-markdown":
+		return text.NewMarkdownReader(req.Body)
+	case "application/json":
+		return text.NewJSONReader(req.Body, s.JSONField)
+	}
+
+	return req.Body
 }
 
 // Returns the connection string.
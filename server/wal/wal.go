@@ -0,0 +1,300 @@
+// Package wal provides the write-ahead log of standalone servers, which
+// run without Raft.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"code.google.com/p/gogoprotobuf/proto"
+	"github.com/goraft/raft/protobuf"
+	"github.com/mauidude/deduper/server/command"
+)
+
+var Logger = log.New(os.Stdout, "[wal] ", log.LstdFlags)
+
+// Log is a write-ahead log of commands. Entries are written in the format
+// of the Raft log, so the data directory of a standalone server can later
+// be used to start the leader of a new cluster.
+type Log struct {
+	// the path and file of the log, opened for appending
+	path string
+	file *os.File
+
+	// the index and term of the last entry
+	index uint64
+	term  uint64
+
+	// the index the commands are applied to
+	context interface{}
+
+	// serializes appends
+	mutex sync.Mutex
+}
+
+// Open opens the log at path, creating it if needed, and applies
+// its entries to the given index. A partly written entry at the end
+// of the log, left by a crash, is removed. An error is returned if
+// any other entry cannot be read.
+func Open(path string, context interface{}) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		path:    path,
+		file:    file,
+		term:    1,
+		context: context,
+	}
+
+	size, err := l.replay()
+	if err == nil {
+		err = file.Truncate(size)
+	}
+
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// replay applies the entries of the log and returns the size
+// of the entries which were read whole.
+func (l *Log) replay() (int64, error) {
+	r := bufio.NewReader(l.file)
+
+	var size int64
+	for {
+		entry := &protobuf.LogEntry{}
+		n, err := decode(r, entry)
+		if err == io.EOF {
+			return size, nil
+		} else if err == io.ErrUnexpectedEOF {
+			// only the last entry can end early
+			Logger.Printf("Truncating partly written entry after entry %d", l.index)
+			return size, nil
+		} else if err != nil {
+			return 0, fmt.Errorf("unable to read entry after entry %d: %v", l.index, err)
+		}
+
+		size += n
+		l.index = entry.GetIndex()
+		l.term = entry.GetTerm()
+
+		// entries added by Raft after a standalone server joined
+		// a cluster do not change the index
+		if strings.HasPrefix(entry.GetCommandName(), "raft:") {
+			continue
+		}
+
+		c, err := command.Decode(entry.GetCommandName(), entry.GetCommand())
+		if err != nil {
+			return 0, err
+		}
+
+		if err := c.ApplyTo(l.context, l.index); err != nil {
+			Logger.Printf("Unable to apply entry %d: %v", l.index, err)
+		}
+	}
+}
+
+// Do appends the command to the log, syncs it to disk and then applies it.
+func (l *Log) Do(c command.Command) (interface{}, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(c); err != nil {
+		return nil, err
+	}
+
+	entry := &protobuf.LogEntry{
+		Index:       proto.Uint64(l.index + 1),
+		Term:        proto.Uint64(l.term),
+		CommandName: proto.String(c.CommandName()),
+		Command:     b.Bytes(),
+	}
+
+	pos, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := encode(l.file, entry); err != nil {
+		l.rollback(pos)
+		return nil, err
+	}
+
+	if err := l.file.Sync(); err != nil {
+		l.rollback(pos)
+		return nil, err
+	}
+
+	l.index++
+	return nil, c.ApplyTo(l.context, l.index)
+}
+
+// Compact drops the entries of the log up to the given index, whose
+// commands are durable in the index, so the log does not grow forever
+// and fewer entries are read when it is opened. The entry at the given
+// index is kept, so later entries keep their indexes. The log is
+// rewritten to a new file which replaces it.
+func (l *Log) Compact(applied uint64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// the log is appended to at its end
+	defer func() { l.file.Seek(0, io.SeekEnd) }()
+
+	r := bufio.NewReader(l.file)
+	var kept []*protobuf.LogEntry
+	dropped := 0
+
+	for {
+		entry := &protobuf.LogEntry{}
+		if _, err := decode(r, entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if entry.GetIndex() <= applied && len(kept) > 0 {
+			kept = kept[:0]
+			dropped++
+		}
+
+		kept = append(kept, entry)
+	}
+
+	if dropped == 0 {
+		return nil
+	}
+
+	tmp := l.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, entry := range kept {
+		if err = encode(w, entry); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	l.file.Close()
+	l.file = f
+
+	Logger.Printf("Dropped %d entries up to entry %d", dropped, applied)
+	return syncDir(filepath.Dir(l.path))
+}
+
+// syncDir syncs the directory, so the files renamed into it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// rollback removes a partly written entry, so later
+// entries are not lost when the log is replayed.
+func (l *Log) rollback(pos int64) {
+	if err := l.file.Truncate(pos); err == nil {
+		l.file.Seek(pos, io.SeekStart)
+	}
+}
+
+// Close closes the log.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.file.Close()
+}
+
+// encode writes an entry in the format of the Raft log: its
+// length in hex followed by the entry as a protocol buffer.
+func encode(w io.Writer, entry *protobuf.LogEntry) error {
+	b, err := proto.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%8x\n", len(b)); err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// decode reads an entry written by encode and returns its size. It
+// returns io.EOF at the end of r and io.ErrUnexpectedEOF if the entry
+// ends early.
+func decode(r io.Reader, entry *protobuf.LogEntry) (int64, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	var length int
+	if _, err := fmt.Sscanf(string(header), "%8x\n", &length); err != nil {
+		return 0, fmt.Errorf("invalid entry header %q", header)
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	if err := proto.Unmarshal(b, entry); err != nil {
+		return 0, err
+	}
+
+	return int64(length) + 9, nil
+}
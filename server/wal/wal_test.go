@@ -0,0 +1,190 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mauidude/deduper/minhash"
+	"github.com/mauidude/deduper/server/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockIndex struct {
+	added   []string
+	entries []uint64
+}
+
+func (m *mockIndex) Add(id string, r io.Reader) error {
+	return m.AddAt(id, r, 0)
+}
+
+func (m *mockIndex) AddAt(id string, r io.Reader, index uint64) error {
+	b, _ := ioutil.ReadAll(r)
+	m.added = append(m.added, id+"="+string(b))
+	m.entries = append(m.entries, index)
+	return nil
+}
+
+func (m *mockIndex) AddHashed(id string, hashed []byte) error {
+	return m.AddHashedAt(id, hashed, minhash.Stamp{}, 0)
+}
+
+func (m *mockIndex) AddHashedAt(id string, hashed []byte, stamp minhash.Stamp, index uint64) error {
+	return m.AddAt(id, bytes.NewReader(hashed), index)
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+
+	idx := &mockIndex{}
+	l, err := Open(path, idx)
+	require.NoError(t, err)
+
+	_, err = l.Do(command.NewAddCommand("1", []byte("one"), minhash.Stamp{}))
+	require.NoError(t, err)
+	_, err = l.Do(command.NewWriteCommand("2", "two"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	assert.Equal(t, []string{"1=one", "2=two"}, idx.added)
+	assert.Equal(t, []uint64{1, 2}, idx.entries)
+
+	// a partly written entry is removed when the log is replayed
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString("      20\npartial")
+	require.NoError(t, err)
+	f.Close()
+
+	idx = &mockIndex{}
+	l, err = Open(path, idx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1=one", "2=two"}, idx.added)
+	assert.Equal(t, []uint64{1, 2}, idx.entries)
+
+	_, err = l.Do(command.NewAddCommand("3", []byte("three"), minhash.Stamp{}))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	idx = &mockIndex{}
+	l, err = Open(path, idx)
+	require.NoError(t, err)
+	defer l.Close()
+
+	assert.Equal(t, []string{"1=one", "2=two", "3=three"}, idx.added)
+	assert.Equal(t, []uint64{1, 2, 3}, idx.entries)
+}
+
+func TestLog_Corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+
+	l, err := Open(path, &mockIndex{})
+	require.NoError(t, err)
+
+	_, err = l.Do(command.NewWriteCommand("1", "one"))
+	require.NoError(t, err)
+	_, err = l.Do(command.NewWriteCommand("2", "two"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	// an entry which is not at the end of the log cannot be read
+	corrupt := append([]byte("garbage!\n"), b...)
+	require.NoError(t, ioutil.WriteFile(path, corrupt, 0600))
+
+	_, err = Open(path, &mockIndex{})
+	assert.Error(t, err)
+
+	// and the log is left as it was
+	after, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, corrupt, after)
+}
+
+func TestLog_Compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	c := &minhash.Config{Bands: 10, Rows: 2, ShingleSize: 2}
+
+	mh, err := minhash.Open(c, filepath.Join(dir, "index"))
+	require.NoError(t, err)
+
+	l, err := Open(path, mh)
+	require.NoError(t, err)
+
+	docs := []string{
+		"the quick brown fox jumps over the lazy dog",
+		"a stitch in time saves nine",
+		"all that glitters is not gold",
+		"an apple a day keeps the doctor away",
+	}
+
+	for i, doc := range docs[:3] {
+		_, err = l.Do(command.NewWriteCommand(strconv.Itoa(i), doc))
+		require.NoError(t, err)
+	}
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	before := fi.Size()
+
+	// the log shrinks once the checkpoint is durable
+	require.NoError(t, mh.Checkpoint())
+	applied, err := mh.Checkpointed()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), applied)
+	require.NoError(t, l.Compact(applied))
+
+	fi, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, fi.Size() < before)
+
+	// and later entries keep their indexes
+	_, err = l.Do(command.NewWriteCommand("3", docs[3]))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	idx := &mockIndex{}
+	l, err = Open(path, idx)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	assert.Equal(t, []uint64{3, 4}, idx.entries)
+
+	// the checkpoint and the rest of the log rebuild the index
+	mh, err = minhash.Open(c, filepath.Join(dir, "index"))
+	require.NoError(t, err)
+
+	l, err = Open(path, mh)
+	require.NoError(t, err)
+	defer l.Close()
+
+	assert.Equal(t, 4, mh.Stats().Documents)
+	for i, doc := range docs {
+		matches, err := mh.FindSimilar(strings.NewReader(doc), 1)
+		require.NoError(t, err)
+		if assert.Len(t, matches, 1, doc) {
+			assert.Equal(t, strconv.Itoa(i), matches[0].ID)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Package simhash finds near duplicate documents using Charikar's SimHash.
+// Each document is reduced to a 64-bit fingerprint, and documents whose
+// fingerprints differ in at most k bits are similar.
+package simhash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+
+	"github.com/mauidude/deduper/minhash"
+	"github.com/mauidude/deduper/text"
+)
+
+// NewFromConfig creates a new SimHasher from the given config.
+func NewFromConfig(c *minhash.Config) (*SimHasher, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	analyzer, err := text.NewAnalyzer(c.Normalizers, c.Tokenizer, c.StopWords)
+	if err != nil {
+		return nil, err
+	}
+	analyzer.AllowShort = c.ShortDocuments != minhash.ShortReject
+
+	return &SimHasher{
+		config:   *c,
+		analyzer: analyzer,
+		n:        c.ShingleSize,
+		k:        c.Distance,
+		seed:     uint64(c.HashSeed()),
+		tables:   newTables(c.Distance),
+		ids:      make(map[string]bool),
+	}, nil
+}
+
+// SimHasher provides near-similar matching of documents by the Hamming
+// distance between their fingerprints.
+type SimHasher struct {
+	// The config the SimHasher was created with.
+	config minhash.Config
+
+	// Splits documents into shingles.
+	analyzer *text.Analyzer
+
+	// N-shingles being used.
+	n int
+
+	// The largest Hamming distance between similar documents.
+	k int
+
+	// Seeds the hash of each shingle.
+	seed uint64
+
+	// The fingerprints of the documents and their ids.
+	fingerprints []uint64
+	columnIDs    []string
+
+	// The unique list of document ids being stored.
+	ids map[string]bool
+
+	// The lookup tables of fingerprint positions.
+	tables []table
+
+	// Locks the fingerprints, ids and tables.
+	mutex sync.RWMutex
+}
+
+// table indexes fingerprints by the bits selected by mask. Fingerprints
+// within distance k of each other agree on all the bits of at least
+// one of k+1 tables with disjoint masks.
+type table struct {
+	mask    uint64
+	buckets map[uint64][]int
+}
+
+// newTables creates k+1 tables whose masks split the 64 bits
+// of a fingerprint into blocks of nearly equal size.
+func newTables(k int) []table {
+	tables := make([]table, k+1)
+
+	lo := 0
+	for i := range tables {
+		hi := 64 * (i + 1) / len(tables)
+
+		var mask uint64
+		for b := lo; b < hi; b++ {
+			mask |= 1 << uint(b)
+		}
+
+		tables[i] = table{
+			mask:    mask,
+			buckets: make(map[uint64][]int),
+		}
+		lo = hi
+	}
+
+	return tables
+}
+
+// Add adds a new document with the given ID to the collection of
+// documents. An error is returned, and nothing is added, if the document
+// cannot be read or has too little text to be hashed.
+func (s *SimHasher) Add(id string, r io.Reader) error {
+	fp, err := s.Fingerprint(r)
+	if err != nil {
+		return err
+	}
+
+	s.add(id, fp)
+	return nil
+}
+
+func (s *SimHasher) add(id string, fp uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := len(s.fingerprints)
+	s.fingerprints = append(s.fingerprints, fp)
+	s.columnIDs = append(s.columnIDs, id)
+	s.ids[id] = true
+
+	for _, t := range s.tables {
+		key := fp & t.mask
+		t.buckets[key] = append(t.buckets[key], i)
+	}
+}
+
+// Hash returns the fingerprint of the document read from r, encoded so it
+// can be added to any SimHasher created from the same config with AddHashed.
+func (s *SimHasher) Hash(r io.Reader) ([]byte, error) {
+	fp, err := s.Fingerprint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, fp)
+
+	return b, nil
+}
+
+// AddHashed adds a document hashed by Hash with the given ID
+// to the collection of documents.
+func (s *SimHasher) AddHashed(id string, hashed []byte) error {
+	if len(hashed) != 8 {
+		return fmt.Errorf("fingerprint has %d bytes, expected 8", len(hashed))
+	}
+
+	s.add(id, binary.BigEndian.Uint64(hashed))
+	return nil
+}
+
+// FindSimilar returns a list of documents whose fingerprints are within the
+// configured Hamming distance of the given document's fingerprint and whose
+// similarity is greater than or equal to the threshold provided. The
+// similarity is the fraction of the 64 fingerprint bits which are equal.
+// An error is returned if the document cannot be read or has too little
+// text to be hashed.
+func (s *SimHasher) FindSimilar(r io.Reader, threshold float64) ([]minhash.Match, error) {
+	fp, err := s.Fingerprint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.similar(fp, threshold), nil
+}
+
+func (s *SimHasher) similar(fp uint64, threshold float64) []minhash.Match {
+	similar := make([]minhash.Match, 0)
+	seen := make(map[int]bool)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, t := range s.tables {
+		for _, i := range t.buckets[fp&t.mask] {
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+
+			d := bits.OnesCount64(fp ^ s.fingerprints[i])
+			if d > s.k {
+				continue
+			}
+
+			if sim := Similarity(d); sim >= threshold {
+				similar = append(similar, minhash.Match{
+					ID:         s.columnIDs[i],
+					Similarity: sim,
+				})
+			}
+		}
+	}
+
+	return similar
+}
+
+// Fingerprint returns the 64-bit SimHash fingerprint of the document read
+// from r. Each bit is set if most of the document's shingles hash to a
+// value with that bit set.
+func (s *SimHasher) Fingerprint(r io.Reader) (uint64, error) {
+	var counts [64]int
+
+	shingler := s.analyzer.Shingler(r, s.n)
+
+	shingles := 0
+	for shingler.Scan() {
+		shingles++
+
+		h := minhash.Mix64(shingler.Hash() ^ s.seed)
+		for b := range counts {
+			if h&(1<<uint(b)) != 0 {
+				counts[b]++
+			} else {
+				counts[b]--
+			}
+		}
+	}
+
+	if err := shingler.Err(); err != nil {
+		return 0, err
+	}
+
+	if shingler.Tokens() == 0 {
+		return 0, minhash.ErrEmptyDocument
+	}
+
+	if shingles == 0 {
+		return 0, minhash.ErrDocumentTooShort
+	}
+
+	var fp uint64
+	for b, c := range counts {
+		if c > 0 {
+			fp |= 1 << uint(b)
+		}
+	}
+
+	return fp, nil
+}
+
+// Config returns the config the SimHasher was created with.
+func (s *SimHasher) Config() minhash.Config {
+	return s.config
+}
+
+// Contains returns true if the SimHasher contains
+// the document with the given id.
+func (s *SimHasher) Contains(id string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.ids[id]
+}
+
+// Stats returns the number of documents in the SimHasher
+// and the memory they use.
+func (s *SimHasher) Stats() minhash.Stats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// each document is in a bucket of every table
+	bytes := int64(8*cap(s.fingerprints)+16*cap(s.columnIDs)) + int64(8*len(s.tables)*len(s.fingerprints))
+	for _, t := range s.tables {
+		bytes += int64(48 * len(t.buckets))
+	}
+
+	for id := range s.ids {
+		bytes += int64(len(id) + 48)
+	}
+
+	return minhash.NewStats(len(s.fingerprints), len(s.ids), bytes)
+}
+
+// Similarity returns the similarity of two fingerprints
+// which differ in d bits.
+func Similarity(d int) float64 {
+	return 1 - float64(d)/64
+}
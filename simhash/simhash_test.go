@@ -0,0 +1,145 @@
+package simhash
+
+import (
+	"math/bits"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mauidude/deduper/minhash"
+	"github.com/mauidude/deduper/text"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSimHasher(t *testing.T, distance int) *SimHasher {
+	sh, err := NewFromConfig(&minhash.Config{
+		Algorithm:   minhash.AlgorithmSimHash,
+		Distance:    distance,
+		Bands:       1,
+		Rows:        1,
+		ShingleSize: 2,
+		Normalizers: text.DefaultNormalizers,
+		Seed:        31,
+	})
+	require.NoError(t, err)
+
+	return sh
+}
+
+func TestSimHasher(t *testing.T) {
+	sh := newTestSimHasher(t, minhash.DefaultDistance)
+
+	require.NoError(t, sh.Add("1", strings.NewReader(`Lorem ipsum dolor sit amet, consectetur adipiscing elit. Sed sed felis vestibulum, mollis libero eget, pharetra lorem. Sed ut vestibulum tortor. Suspendisse sem nisl, semper eu sem non, tempor viverra ante. Morbi quis nunc non orci fermentum fringilla sit amet nec nisi. Morbi laoreet commodo porta. Ut bibendum porttitor bibendum. Nulla scelerisque eu sem at efficitur. Quisque a imperdiet massa.`)))
+	require.NoError(t, sh.Add("2", strings.NewReader(`Nulla dapibus lorem nunc, nec tempus purus dictum vel. Nullam lacinia ultricies cursus. Ut quis lectus efficitur, porta dolor nec, ornare tellus. Nunc felis orci, scelerisque mollis elementum sed, laoreet mollis sem. Sed sollicitudin massa ultricies ultricies hendrerit. Lorem ipsum dolor sit amet, consectetur adipiscing elit. Nullam finibus lobortis commodo. In dignissim urna a neque lacinia mattis.`)))
+
+	results, err := sh.FindSimilar(strings.NewReader(`Cras gravida bibendum venenatis. Nulla tempus ante eget rutrum maximus. Pellentesque vel lorem nisi. Nullam varius neque sed lectus feugiat, ac vestibulum nisi porttitor.`), 0)
+	require.NoError(t, err)
+	assert.Len(t, results, 0)
+
+	results, err = sh.FindSimilar(strings.NewReader(`lorem ipsum dolor sit amet consectetur adipiscing elit sed sed felis vestibulum mollis libero eget pharetra lorem sed ut vestibulum tortor suspendisse sem nisl semper eu sem non tempor viverra ante morbi quis nunc non orci fermentum fringilla sit amet nec nisi morbi laoreet commodo porta ut bibendum porttitor bibendum nulla scelerisque eu sem at efficitur quisque a imperdiet massa`), 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "1", results[0].ID)
+	assert.Equal(t, 1.0, results[0].Similarity)
+
+	assert.True(t, sh.Contains("1"))
+	assert.False(t, sh.Contains("3"))
+
+	_, err = sh.FindSimilar(strings.NewReader(" "), 0)
+	assert.Equal(t, minhash.ErrEmptyDocument, err)
+}
+
+func TestSimHasher_Tables(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+
+	for k := 0; k < 8; k++ {
+		tables := newTables(k)
+		assert.Len(t, tables, k+1)
+
+		// the masks cover every bit exactly once
+		var all uint64
+		for _, t1 := range tables {
+			assert.Equal(t, uint64(0), all&t1.mask)
+			all |= t1.mask
+		}
+		assert.Equal(t, ^uint64(0), all)
+
+		// flipping k bits always leaves one block unchanged
+		for i := 0; i < 100; i++ {
+			fp := r.Uint64()
+			other := fp
+			for bits.OnesCount64(fp^other) < k {
+				other ^= 1 << uint(r.Intn(64))
+			}
+
+			found := false
+			for _, t1 := range tables {
+				found = found || fp&t1.mask == other&t1.mask
+			}
+			assert.True(t, found)
+		}
+	}
+}
+
+func TestSimHasher_Distance(t *testing.T) {
+	fp := uint64(0xdeadbeefcafebabe)
+	similar := func(distance int, threshold float64) map[string]float64 {
+		sh := newTestSimHasher(t, distance)
+		for d := 0; d <= 5; d++ {
+			other := fp
+			for b := 0; b < d; b++ {
+				other ^= 1 << uint(b*13)
+			}
+
+			sh.add(string('0'+rune(d)), other)
+		}
+
+		sims := make(map[string]float64)
+		for _, m := range sh.similar(fp, threshold) {
+			sims[m.ID] = m.Similarity
+		}
+
+		return sims
+	}
+
+	// only fingerprints within the distance are matched
+	assert.Equal(t, map[string]float64{"0": 1, "1": 63.0 / 64, "2": 62.0 / 64, "3": 61.0 / 64}, similar(minhash.DefaultDistance, 0))
+	assert.Equal(t, map[string]float64{"0": 1}, similar(0, 0))
+
+	// and the threshold filters them further
+	assert.Len(t, similar(minhash.DefaultDistance, 62.0/64), 3)
+}
+
+func TestSimHasher_AddHashed(t *testing.T) {
+	sh := newTestSimHasher(t, minhash.DefaultDistance)
+
+	hashed, err := sh.Hash(strings.NewReader("the quick brown fox jumps over the lazy dog"))
+	require.NoError(t, err)
+	assert.False(t, sh.Contains("1"))
+
+	require.NoError(t, sh.AddHashed("1", hashed))
+	assert.True(t, sh.Contains("1"))
+
+	results, err := sh.FindSimilar(strings.NewReader("the quick brown fox jumps over the lazy dog"), 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	assert.Error(t, sh.AddHashed("2", []byte{1, 2, 3}))
+}
+
+func TestSimHasher_Seed(t *testing.T) {
+	fingerprint := func(seed int64) uint64 {
+		sh, err := NewFromConfig(&minhash.Config{Algorithm: minhash.AlgorithmSimHash, Bands: 1, Rows: 1, ShingleSize: 2, Seed: seed})
+		require.NoError(t, err)
+
+		fp, err := sh.Fingerprint(strings.NewReader("the quick brown fox jumps over the lazy dog"))
+		require.NoError(t, err)
+
+		return fp
+	}
+
+	// a zero seed is the fixed seed of the minhash families
+	assert.Equal(t, fingerprint(31), fingerprint(0))
+	assert.NotEqual(t, fingerprint(31), fingerprint(42))
+}
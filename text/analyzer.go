@@ -0,0 +1,69 @@
+package text
+
+import (
+	"bufio"
+	"io"
+)
+
+// Analyzer holds the options used to turn text into shingles, so
+// that every document of an index is shingled the same way.
+type Analyzer struct {
+	// Split splits text into tokens.
+	Split bufio.SplitFunc
+
+	// Normalize normalizes each token, may be nil.
+	Normalize Normalizer
+
+	// StopWords are dropped after normalizing, may be nil.
+	StopWords StopWords
+
+	// AllowShort is whether text with fewer tokens than the shingle
+	// size is a single shingle.
+	AllowShort bool
+}
+
+// NewAnalyzer creates an Analyzer from the names of its normalizers,
+// tokenizer and stop word language. An empty tokenizer is the
+// DefaultTokenizer and an empty language removes no stop words.
+func NewAnalyzer(normalizers []string, tokenizer string, stopWords string) (*Analyzer, error) {
+	a := &Analyzer{}
+
+	// without normalizers the shingler can avoid copying tokens
+	var err error
+	if len(normalizers) > 0 {
+		if a.Normalize, err = NewNormalizer(normalizers...); err != nil {
+			return nil, err
+		}
+	}
+
+	if tokenizer == "" {
+		tokenizer = DefaultTokenizer
+	}
+
+	if a.Split, err = Tokenizer(tokenizer); err != nil {
+		return nil, err
+	}
+
+	if stopWords != "" {
+		if a.StopWords, err = NewStopWords(stopWords); err != nil {
+			return nil, err
+		}
+
+		if a.Normalize != nil {
+			a.StopWords = a.StopWords.Normalize(a.Normalize)
+		}
+	}
+
+	return a, nil
+}
+
+// Shingler returns a shingler of size n for the text read from r.
+func (a *Analyzer) Shingler(r io.Reader, n int) *Shingler {
+	s := NewShingler(r, n)
+	s.Split(a.Split)
+	s.Normalize(a.Normalize)
+	s.StopWords(a.StopWords)
+	s.AllowShort(a.AllowShort)
+
+	return s
+}
@@ -0,0 +1,208 @@
+package text
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io"
+)
+
+const (
+	// text is flushed at the first space after this many bytes
+	// so entities are never split
+	htmlChunkSize = 4096
+
+	// text is always flushed after this many bytes
+	htmlMaxChunkSize = 64 * 1024
+)
+
+// elements whose contents are not visible text
+var htmlRawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"template": true,
+	"noscript": true,
+}
+
+// elements which do not separate words
+var htmlInlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true,
+	"code": true, "data": true, "dfn": true, "em": true, "font": true, "i": true,
+	"kbd": true, "mark": true, "q": true, "s": true, "samp": true, "small": true,
+	"span": true, "strong": true, "sub": true, "sup": true, "time": true, "u": true,
+	"var": true,
+}
+
+// NewHTMLReader returns a reader of the visible text of the HTML document
+// read from r. Tags, comments, scripts and styles are removed and entities
+// are unescaped.
+func NewHTMLReader(r io.Reader) io.Reader {
+	return &htmlReader{
+		r: bufio.NewReader(r),
+	}
+}
+
+type htmlReader struct {
+	// the reader of the HTML document
+	r *bufio.Reader
+
+	// text which has not been unescaped yet
+	text []byte
+
+	// unescaped text waiting to be read
+	out bytes.Buffer
+
+	// the first error from the underlying reader
+	err error
+}
+
+// Read reads the next visible text into p.
+func (h *htmlReader) Read(p []byte) (int, error) {
+	for h.out.Len() == 0 && h.err == nil {
+		h.err = h.step()
+	}
+
+	if h.out.Len() > 0 {
+		return h.out.Read(p)
+	}
+
+	return 0, h.err
+}
+
+// step consumes the next byte of text or the next tag.
+func (h *htmlReader) step() error {
+	c, err := h.r.ReadByte()
+	if err != nil {
+		h.flush()
+		return err
+	}
+
+	if c == '<' {
+		h.flush()
+		return h.tag()
+	}
+
+	h.text = append(h.text, c)
+	if len(h.text) >= htmlMaxChunkSize || len(h.text) >= htmlChunkSize && isHTMLSpace(c) {
+		h.flush()
+	}
+
+	return nil
+}
+
+// flush unescapes the pending text.
+func (h *htmlReader) flush() {
+	if len(h.text) > 0 {
+		h.out.WriteString(html.UnescapeString(string(h.text)))
+		h.text = h.text[:0]
+	}
+}
+
+// tag consumes a tag, comment or declaration following a '<'.
+func (h *htmlReader) tag() error {
+	c, err := h.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c == '!':
+		if b, _ := h.r.Peek(2); string(b) == "--" {
+			h.r.Discard(2)
+			return h.skipUntil("-->")
+		}
+
+		return h.skipUntil(">")
+	case c == '?':
+		return h.skipUntil(">")
+	case c != '/' && !isHTMLLetter(c):
+		// not a tag, just a less than sign
+		h.text = append(h.text, '<', c)
+		return nil
+	}
+
+	closing := c == '/'
+	if !closing {
+		h.r.UnreadByte()
+	}
+
+	// read the element name
+	name := make([]byte, 0, 8)
+	for {
+		c, err = h.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if !isHTMLLetter(c) && !('0' <= c && c <= '9') {
+			break
+		}
+
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		name = append(name, c)
+	}
+
+	// skip the attributes
+	var quote, prev byte
+	for c != '>' || quote != 0 {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		}
+
+		prev = c
+		if c, err = h.r.ReadByte(); err != nil {
+			return err
+		}
+	}
+
+	if !htmlInlineElements[string(name)] {
+		h.out.WriteByte(' ')
+	}
+
+	if !closing && prev != '/' && htmlRawTextElements[string(name)] {
+		if err := h.skipUntil("</" + string(name)); err != nil {
+			return err
+		}
+
+		return h.skipUntil(">")
+	}
+
+	return nil
+}
+
+// skipUntil discards input up to and including s, ignoring ASCII case.
+func (h *htmlReader) skipUntil(s string) error {
+	window := make([]byte, 0, len(s))
+	for {
+		c, err := h.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+
+		if len(window) == len(s) {
+			window = append(window[:0], window[1:]...)
+		}
+		window = append(window, c)
+
+		if string(window) == s {
+			return nil
+		}
+	}
+}
+
+func isHTMLLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,29 @@
+package text
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLReader(t *testing.T) {
+	input := `<!DOCTYPE html>
+<html>
+<head><title>The Title</title><style>body { color: red; }</style></head>
+<body>
+<!-- a <b>comment</b> -->
+<p class="intro" data-x='1 > 0'>Hello, <b>Wor</b>ld &amp; friends</p><p>Second</p>
+<SCRIPT type="text/javascript">var x = "<p>not text</p>";</SCRIPT>
+<img src="a.png"/>a &lt; b
+</body>
+</html>`
+
+	b, err := ioutil.ReadAll(NewHTMLReader(iotest.OneByteReader(strings.NewReader(input))))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"The", "Title", "Hello,", "World", "&", "friends", "Second", "a", "<", "b"}, strings.Fields(string(b)))
+}
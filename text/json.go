@@ -0,0 +1,150 @@
+package text
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrTrailingData is returned when a JSON document is followed by more data.
+var ErrTrailingData = errors.New("unexpected data after the JSON document")
+
+// NewJSONReader returns a reader of the string values found at the given
+// path in the JSON document read from r. The path is a dot separated list of
+// object keys where "*" matches every key. Arrays are searched element by
+// element. An empty path returns every string in the document. The document
+// is read token by token, so the values outside the path are never held in
+// memory.
+func NewJSONReader(r io.Reader, path string) io.Reader {
+	dec := json.NewDecoder(r)
+
+	var keys []string
+	if path != "" {
+		keys = strings.Split(path, ".")
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return &errReader{err}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := collectJSONStrings(dec, tok, buf, keys); err != nil {
+		return &errReader{err}
+	}
+
+	// only whitespace may follow the document
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = ErrTrailingData
+		}
+
+		return &errReader{err}
+	}
+
+	return buf
+}
+
+// collectJSONStrings reads the JSON value starting with tok from dec and
+// writes each string in it found at the path of keys to buf in the order
+// of the document, separated by newlines.
+func collectJSONStrings(dec *json.Decoder, tok json.Token, buf *bytes.Buffer, keys []string) error {
+	switch tok {
+	case json.Delim('['):
+		for dec.More() {
+			if err := collectJSONValue(dec, buf, keys); err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		rest := keys
+		if len(keys) > 0 {
+			rest = keys[1:]
+		}
+
+		for dec.More() {
+			tok, err := nextJSONToken(dec)
+			if err != nil {
+				return err
+			}
+
+			key, _ := tok.(string)
+			if len(keys) > 0 && keys[0] != "*" && key != keys[0] {
+				if err := skipJSONValue(dec); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := collectJSONValue(dec, buf, rest); err != nil {
+				return err
+			}
+		}
+	default:
+		if s, ok := tok.(string); ok && len(keys) == 0 {
+			buf.WriteString(s)
+			buf.WriteByte('\n')
+		}
+
+		return nil
+	}
+
+	// the closing delimiter
+	_, err := nextJSONToken(dec)
+	return err
+}
+
+// collectJSONValue is collectJSONStrings for the next value of dec.
+func collectJSONValue(dec *json.Decoder, buf *bytes.Buffer, keys []string) error {
+	tok, err := nextJSONToken(dec)
+	if err != nil {
+		return err
+	}
+
+	return collectJSONStrings(dec, tok, buf, keys)
+}
+
+// skipJSONValue reads the next value of dec without keeping it.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := nextJSONToken(dec)
+		if err != nil {
+			return err
+		}
+
+		switch tok {
+		case json.Delim('['), json.Delim('{'):
+			depth++
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// nextJSONToken returns the next token of a value which has started,
+// so the end of the input is unexpected.
+func nextJSONToken(dec *json.Decoder) (json.Token, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return tok, err
+}
+
+// errReader is a reader which always returns an error.
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
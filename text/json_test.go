@@ -0,0 +1,68 @@
+package text
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONReader(t *testing.T) {
+	input := `{"title": "A title", "body": {"text": "some text", "id": 5}, "comments": [{"text": "first"}, {"text": "second"}]}`
+
+	cases := []struct {
+		path     string
+		expected []string
+	}{
+		{"", []string{"A title", "some text", "first", "second"}},
+		{"body.text", []string{"some text"}},
+		{"comments.text", []string{"first", "second"}},
+		{"*.text", []string{"some text", "first", "second"}},
+		{"missing", nil},
+	}
+
+	for _, c := range cases {
+		b, err := ioutil.ReadAll(NewJSONReader(strings.NewReader(input), c.path))
+		require.NoError(t, err)
+
+		var lines []string
+		if s := strings.TrimSuffix(string(b), "\n"); s != "" {
+			lines = strings.Split(s, "\n")
+		}
+
+		assert.Equal(t, c.expected, lines, c.path)
+	}
+}
+
+func TestJSONReader_Invalid(t *testing.T) {
+	_, err := ioutil.ReadAll(NewJSONReader(strings.NewReader(`{"text": `), ""))
+	assert.Error(t, err)
+
+	_, err = ioutil.ReadAll(NewJSONReader(strings.NewReader(`{"text": "a"]`), "text"))
+	assert.Error(t, err)
+
+	// values outside the path must still be valid
+	_, err = ioutil.ReadAll(NewJSONReader(strings.NewReader(`{"other": [1, }, "text": "a"}`), "text"))
+	assert.Error(t, err)
+}
+
+func TestJSONReader_TrailingData(t *testing.T) {
+	b, err := ioutil.ReadAll(NewJSONReader(strings.NewReader("{\"text\": \"a\"} \n\t"), "text"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\n", string(b))
+
+	for _, input := range []string{`{"text": "a"} x`, `{"text": "a"}{"text": "b"}`, `"a" "b"`} {
+		_, err := ioutil.ReadAll(NewJSONReader(strings.NewReader(input), ""))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestJSONReader_Order(t *testing.T) {
+	// the strings are read in the order of the document, with every
+	// value of a repeated key
+	b, err := ioutil.ReadAll(NewJSONReader(strings.NewReader(`{"text": "b", "id": "a", "text": {"x": "c"}}`), ""))
+	require.NoError(t, err)
+	assert.Equal(t, "b\na\nc\n", string(b))
+}
@@ -0,0 +1,90 @@
+package text
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io"
+	"regexp"
+)
+
+var (
+	// markdown at the start of a line: headings, quotes, list items and table rows
+	markdownBlockPrefix = regexp.MustCompile(`^\s*(#{1,6}\s+|(>\s*)+|[-*+]\s+|\d+[.)]\s+|\|)`)
+
+	// lines which contain only markdown: rules, fences, table delimiters and link definitions
+	markdownSyntaxLine = regexp.MustCompile(`^\s*(([-*_]\s*){3,}|(` + "```" + `|~~~).*|[|:\- ]+|\[[^\]]+\]:\s+\S+.*)$`)
+
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]*)\](\([^)]*\)|\[[^\]]*\])`)
+	markdownAutoLink = regexp.MustCompile(`<[a-zA-Z][a-zA-Z0-9+.-]*:[^>\s]*>`)
+	markdownTag      = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	markdownEmphasis = regexp.MustCompile("[*~`|]+|#+\\s*$")
+)
+
+// NewMarkdownReader returns a reader of the visible text of the Markdown
+// document read from r. Formatting is removed, links and images are replaced
+// with their text and inline HTML tags are removed.
+func NewMarkdownReader(r io.Reader) io.Reader {
+	return &markdownReader{
+		r: bufio.NewReader(r),
+	}
+}
+
+type markdownReader struct {
+	// the reader of the Markdown document
+	r *bufio.Reader
+
+	// text waiting to be read
+	out bytes.Buffer
+
+	// true if the next chunk read continues a line
+	// which was too long for the buffer
+	continued bool
+
+	// the first error from the underlying reader
+	err error
+}
+
+// Read reads the next visible text into p.
+func (m *markdownReader) Read(p []byte) (int, error) {
+	for m.out.Len() == 0 && m.err == nil {
+		m.err = m.step()
+	}
+
+	if m.out.Len() > 0 {
+		return m.out.Read(p)
+	}
+
+	return 0, m.err
+}
+
+// step converts the next line of Markdown. Very long lines are
+// converted in chunks.
+func (m *markdownReader) step() error {
+	line, isPrefix, err := m.r.ReadLine()
+	if err != nil {
+		return err
+	}
+
+	if m.continued || !markdownSyntaxLine.Match(line) {
+		if !m.continued {
+			line = markdownBlockPrefix.ReplaceAll(line, nil)
+		}
+
+		line = markdownImage.ReplaceAll(line, []byte("$1"))
+		line = markdownLink.ReplaceAll(line, []byte("$1"))
+		line = markdownAutoLink.ReplaceAll(line, nil)
+		line = markdownTag.ReplaceAll(line, []byte(" "))
+		line = markdownEmphasis.ReplaceAll(line, []byte(" "))
+
+		m.out.WriteString(html.UnescapeString(string(line)))
+	}
+
+	if !isPrefix {
+		m.out.WriteByte('\n')
+	}
+
+	m.continued = isPrefix
+	return nil
+}
@@ -0,0 +1,32 @@
+package text
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkdownReader(t *testing.T) {
+	input := "# The *Title* #\n" +
+		"\n" +
+		"> Some **bold** and `code` text with a [link](http://example.com).\n" +
+		"\n" +
+		"---\n" +
+		"- ![an image](a.png) item\n" +
+		"1. <span>numbered</span> item <http://example.com>\n" +
+		"```go\n" +
+		"x := 1\n" +
+		"```\n" +
+		"| a | b |\n" +
+		"|---|:-:|\n" +
+		"[ref]: http://example.com\n"
+
+	b, err := ioutil.ReadAll(NewMarkdownReader(strings.NewReader(input)))
+	require.NoError(t, err)
+
+	expected := "The Title Some bold and code text with a link. an image item numbered item x := 1 a b"
+	assert.Equal(t, expected, strings.Join(strings.Fields(string(b)), " "))
+}
@@ -0,0 +1,128 @@
+package text
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer transforms a token before it is added to a shingle.
+// The result may be empty, in which case the token is dropped, or
+// contain whitespace, in which case it is split into several tokens.
+type Normalizer func(string) string
+
+// DefaultNormalizers are the normalizers used by new indexes.
+var DefaultNormalizers = []string{"nfkc", "casefold", "punctuation", "whitespace"}
+
+var normalizers = map[string]Normalizer{
+	"nfkc":        NFKC,
+	"casefold":    CaseFold,
+	"punctuation": StripPunctuation,
+	"whitespace":  CollapseWhitespace,
+	"diacritics":  StripDiacritics,
+	"numbers":     MaskNumbers,
+}
+
+// NewNormalizer chains the normalizers with the given names, in order,
+// into a single Normalizer. An error is returned if a name is unknown.
+func NewNormalizer(names ...string) (Normalizer, error) {
+	chain := make([]Normalizer, 0, len(names))
+	for _, name := range names {
+		n, ok := normalizers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown normalizer %q", name)
+		}
+
+		chain = append(chain, n)
+	}
+
+	return func(s string) string {
+		for _, n := range chain {
+			s = n(s)
+		}
+
+		return s
+	}, nil
+}
+
+// NFKC applies Unicode compatibility composition, so that eg.
+// ligatures and full-width forms compare equal to their plain forms.
+func NFKC(s string) string {
+	return norm.NFKC.String(s)
+}
+
+// CaseFold applies simple Unicode case folding.
+func CaseFold(s string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(unicode.ToUpper(r))
+	}, s)
+}
+
+// StripPunctuation removes all punctuation characters.
+func StripPunctuation(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) {
+			return -1
+		}
+
+		return r
+	}, s)
+}
+
+// CollapseWhitespace replaces each run of whitespace with a single space.
+// Zero-width spaces are treated as whitespace and other invisible formatting
+// characters, such as soft hyphens, are removed.
+func CollapseWhitespace(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\u200b' {
+			return ' '
+		}
+
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+
+		return r
+	}, s)
+
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// StripDiacritics removes combining marks, eg. "café" becomes "cafe".
+func StripDiacritics(s string) string {
+	s = norm.NFD.String(s)
+	s = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+
+		return r
+	}, s)
+
+	return norm.NFC.String(s)
+}
+
+// MaskNumbers replaces each run of digits with a single '#'
+// so that documents differing only in numbers compare equal.
+func MaskNumbers(s string) string {
+	b := make([]rune, 0, len(s))
+	digits := false
+
+	for _, r := range s {
+		if unicode.IsDigit(r) {
+			if !digits {
+				b = append(b, '#')
+			}
+
+			digits = true
+			continue
+		}
+
+		digits = false
+		b = append(b, r)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,38 @@
+package text
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizers(t *testing.T) {
+	cases := []struct {
+		name       string
+		normalizer Normalizer
+		input      string
+		expected   string
+	}{
+		{"nfkc", NFKC, "ﬁne ｗｏｒｌｄ", "fine world"},
+		{"casefold", CaseFold, "Hello WORLD", "hello world"},
+		{"punctuation", StripPunctuation, "Hello, world!", "Hello world"},
+		{"whitespace", CollapseWhitespace, " a \t b\u200bc\u00add ", "a b cd"},
+		{"diacritics", StripDiacritics, "café naïve", "cafe naive"},
+		{"numbers", MaskNumbers, "order 12345 of 7", "order # of #"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.normalizer(c.input), c.name)
+	}
+}
+
+func TestNewNormalizer(t *testing.T) {
+	n, err := NewNormalizer(DefaultNormalizers...)
+	require.NoError(t, err)
+
+	assert.Equal(t, n("Hello,"), n("hello"))
+
+	_, err = NewNormalizer("nfkc", "bogus")
+	assert.Error(t, err)
+}
@@ -4,14 +4,28 @@ import (
 	"bufio"
 	"io"
 	"strings"
+	"unicode/utf8"
 )
 
+const (
+	// the base of the polynomial rolling hash of a window
+	shingleBase = 1099511628211
+
+	// the FNV-1a offset basis used to hash tokens
+	tokenOffset = 14695981039346656037
+)
+
+// MaxTokenSize is the maximum size of a token. Longer tokens, such as
+// base64 encoded blobs, are split into several tokens of at most this size.
+const MaxTokenSize = bufio.MaxScanTokenSize
+
 // NewShingler creates a new shingler for the given reader
 // and produce shingles of size n.
 func NewShingler(r io.Reader, n int) *Shingler {
 	return &Shingler{
-		r: r,
-		n: n,
+		r:     r,
+		n:     n,
+		split: bufio.ScanWords,
 	}
 }
 
@@ -26,52 +40,229 @@ type Shingler struct {
 	// the size of the shingles
 	n int
 
-	// the queue of tokens in the current window
-	q []string
+	// the tokens in the current window, a ring starting at head
+	q    [][]byte
+	head int
+
+	// the hashes of the tokens in q
+	hashes []uint64
+
+	// the rolling hash of the current window
+	hash uint64
+
+	// shingleBase^(n-1), used to remove the oldest token from hash
+	pow uint64
+
+	// splits the reader into tokens
+	split bufio.SplitFunc
+
+	// normalizer applied to each token, may be nil
+	normalize Normalizer
+
+	// tokens dropped after normalizing, may be nil
+	stopWords StopWords
+
+	// normalized tokens waiting to enter the window
+	pending []string
+
+	// if true, text with fewer than n tokens is a single shingle
+	allowShort bool
+
+	// the number of tokens read
+	tokens int
+}
+
+// Split sets the function used to split the reader into tokens.
+// The default is bufio.ScanWords. It must be called before the
+// first call to Scan.
+func (s *Shingler) Split(split bufio.SplitFunc) {
+	s.split = split
+}
+
+// StopWords sets the words to drop from the text. It must be
+// called before the first call to Scan.
+func (s *Shingler) StopWords(stopWords StopWords) {
+	s.stopWords = stopWords
+}
+
+// AllowShort sets whether text with fewer than n tokens produces a
+// single shorter shingle of all its tokens. By default it produces
+// no shingles. It must be called before the first call to Scan.
+func (s *Shingler) AllowShort(allow bool) {
+	s.allowShort = allow
+}
+
+// Normalize sets the normalizer applied to each token. It must be
+// called before the first call to Scan.
+func (s *Shingler) Normalize(n Normalizer) {
+	s.normalize = n
 }
 
 // Scan will return true and advance to the next n-gram
-// until an EOF has been reached on the reader
-// at which time it will return false.
+// until an EOF has been reached on the reader or an error
+// occurs at which time it will return false.
 func (s *Shingler) Scan() bool {
 	if s.q == nil {
 		// initialize everything
-		s.q = make([]string, s.n)
+		s.q = make([][]byte, s.n)
+		s.hashes = make([]uint64, s.n)
 		s.s = bufio.NewScanner(s.r)
-		s.s.Split(bufio.ScanWords)
+		s.s.Buffer(nil, 2*MaxTokenSize)
+		s.s.Split(limitTokens(s.split, MaxTokenSize))
 
+		s.pow = 1
 		for i := 0; i < s.n; i++ {
-			next, ok := s.next()
+			next, ok := s.next(s.q[i])
 			if !ok {
-				s.q = s.q[:0]
-				return false
+				// the window holds the i tokens read so far
+				s.q = s.q[:i]
+				s.hashes = s.hashes[:i]
+				return s.allowShort && i > 0
 			}
 
-			s.q = append(s.q[1:], next)
+			h := hashToken(next)
+			s.q[i] = next
+			s.hashes[i] = h
+			s.hash = s.hash*shingleBase + h
+
+			if i > 0 {
+				s.pow *= shingleBase
+			}
 		}
 
 		return true
 	}
 
-	next, ok := s.next()
+	if len(s.q) < s.n {
+		return false
+	}
+
+	// the oldest token is replaced by the next one
+	next, ok := s.next(s.q[s.head])
 	if !ok {
 		return false
 	}
 
-	s.q = append(s.q[1:], next)
+	h := hashToken(next)
+	s.hash = (s.hash-s.hashes[s.head]*s.pow)*shingleBase + h
+	s.q[s.head] = next
+	s.hashes[s.head] = h
+	s.head = (s.head + 1) % s.n
+
 	return true
 }
 
+// Err returns the first non-EOF error that was encountered
+// reading the text.
+func (s *Shingler) Err() error {
+	if s.s == nil {
+		return nil
+	}
+
+	return s.s.Err()
+}
+
+// Tokens returns the number of tokens read so far.
+func (s *Shingler) Tokens() int {
+	return s.tokens
+}
+
+// Hash returns a 64-bit hash of the tokens in the current window. It is
+// computed incrementally from the hashes of the tokens, so it is much
+// cheaper than hashing Text.
+func (s *Shingler) Hash() uint64 {
+	return s.hash
+}
+
 // Text returns the shingles in the current window.
 func (s *Shingler) Text() string {
-	return strings.Join(s.q, " ")
+	b := make([]byte, 0, 16*len(s.q))
+	for i := range s.q {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+
+		b = append(b, s.q[(s.head+i)%len(s.q)]...)
+	}
+
+	return string(b)
+}
+
+// next reads the next token into dst, reusing its memory.
+func (s *Shingler) next(dst []byte) ([]byte, bool) {
+	for len(s.pending) == 0 {
+		if !s.s.Scan() {
+			return dst, false
+		}
+
+		if s.normalize == nil && s.stopWords == nil {
+			s.tokens++
+			return append(dst[:0], s.s.Bytes()...), true
+		}
+
+		token := s.s.Text()
+		if s.normalize == nil {
+			s.pending = append(s.pending, token)
+		} else {
+			// normalizing may remove the token entirely or split it
+			s.pending = strings.Fields(s.normalize(token))
+		}
+
+		s.pending = s.dropStopWords(s.pending)
+	}
+
+	next := s.pending[0]
+	s.pending = s.pending[1:]
+	s.tokens++
+
+	return append(dst[:0], next...), true
+}
+
+func (s *Shingler) dropStopWords(tokens []string) []string {
+	if s.stopWords == nil {
+		return tokens
+	}
+
+	kept := tokens[:0]
+	for _, t := range tokens {
+		if !s.stopWords.Contains(t) {
+			kept = append(kept, t)
+		}
+	}
+
+	return kept
+}
+
+// limitTokens wraps a split function so tokens longer than max are split
+// into several tokens rather than failing the scan.
+func limitTokens(split bufio.SplitFunc, max int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if err != nil || token != nil || atEOF || len(data)-advance < max {
+			return advance, token, err
+		}
+
+		// the token does not fit, so cut it at the last rune boundary
+		end := advance + max
+		for end > advance && end < len(data) && !utf8.RuneStart(data[end]) {
+			end--
+		}
+
+		if end == advance {
+			end = advance + max
+		}
+
+		return end, data[advance:end], nil
+	}
 }
 
-func (s *Shingler) next() (string, bool) {
-	ok := s.s.Scan()
-	if ok {
-		return s.s.Text(), true
+// hashToken returns the 64-bit FNV-1a hash of a token.
+func hashToken(b []byte) uint64 {
+	h := uint64(tokenOffset)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= shingleBase
 	}
 
-	return "", false
+	return h
 }
@@ -1,8 +1,11 @@
 package text
 
 import (
+	"errors"
+	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -59,3 +62,133 @@ func TestShingler_SameLength(t *testing.T) {
 
 	assert.Equal(t, 1, i)
 }
+
+func TestShingler_Normalize(t *testing.T) {
+	n, err := NewNormalizer(DefaultNormalizers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := strings.NewReader("Hello, World -- it's me")
+	s := NewShingler(r, 2)
+	s.Normalize(n)
+
+	expected := []string{"hello world", "world its", "its me"}
+
+	i := 0
+	for s.Scan() {
+		assert.Equal(t, expected[i], s.Text())
+		i++
+	}
+
+	assert.Equal(t, len(expected), i)
+}
+
+func TestShingler_StopWords(t *testing.T) {
+	stopWords, err := NewStopWords("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := strings.NewReader("This is a test of the shingler.")
+	s := NewShingler(r, 2)
+	s.Split(ScanUnicodeWords)
+	s.StopWords(stopWords)
+
+	expected := []string{"test shingler"}
+
+	i := 0
+	for s.Scan() {
+		assert.Equal(t, expected[i], s.Text())
+		i++
+	}
+
+	assert.Equal(t, len(expected), i)
+}
+
+func TestShingler_LongToken(t *testing.T) {
+	blob := strings.Repeat("x", 3*MaxTokenSize+10)
+	r := strings.NewReader("before " + blob + " after")
+	s := NewShingler(r, 1)
+
+	var tokens []string
+	for s.Scan() {
+		tokens = append(tokens, s.Text())
+	}
+
+	assert.NoError(t, s.Err())
+	assert.Len(t, tokens, 6)
+	assert.Equal(t, "before", tokens[0])
+	assert.Equal(t, blob, strings.Join(tokens[1:5], ""))
+	assert.Equal(t, "after", tokens[5])
+}
+
+func TestShingler_Err(t *testing.T) {
+	readErr := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader("this is a test "), iotest.ErrReader(readErr))
+	s := NewShingler(r, 2)
+
+	i := 0
+	for s.Scan() {
+		i++
+	}
+
+	assert.Equal(t, 3, i)
+	assert.Equal(t, readErr, s.Err())
+}
+
+func TestShingler_Short(t *testing.T) {
+	s := NewShingler(strings.NewReader("too short"), 3)
+	assert.False(t, s.Scan())
+	assert.Equal(t, 2, s.Tokens())
+
+	s = NewShingler(strings.NewReader("too short"), 3)
+	s.AllowShort(true)
+
+	assert.True(t, s.Scan())
+	assert.Equal(t, "too short", s.Text())
+	assert.False(t, s.Scan())
+
+	s = NewShingler(strings.NewReader("  "), 3)
+	s.AllowShort(true)
+
+	assert.False(t, s.Scan())
+	assert.Equal(t, 0, s.Tokens())
+}
+
+func TestShingler_Hash(t *testing.T) {
+	// the same shingle hashes the same wherever it appears
+	s := NewShingler(strings.NewReader("a b c a b c b a"), 2)
+
+	hashes := make(map[string]uint64)
+	for s.Scan() {
+		if h, ok := hashes[s.Text()]; ok {
+			assert.Equal(t, h, s.Hash(), s.Text())
+		}
+
+		hashes[s.Text()] = s.Hash()
+	}
+
+	assert.Len(t, hashes, 5)
+	assert.NotEqual(t, hashes["a b"], hashes["b a"])
+}
+
+func BenchmarkShingler(b *testing.B) {
+	text := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 1<<14)
+	normalize, err := NewNormalizer(DefaultNormalizers...)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(text)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		s := NewShingler(strings.NewReader(text), 2)
+		s.Normalize(normalize)
+
+		for s.Scan() {
+			_ = s.Hash()
+		}
+	}
+}
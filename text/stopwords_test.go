@@ -0,0 +1,28 @@
+package text
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopWords(t *testing.T) {
+	s, err := NewStopWords("en")
+	require.NoError(t, err)
+
+	assert.True(t, s.Contains("the"))
+	assert.True(t, s.Contains("The"))
+	assert.False(t, s.Contains("shingler"))
+
+	_, err = NewStopWords("xx")
+	assert.Error(t, err)
+}
+
+func TestStopWords_Normalize(t *testing.T) {
+	s, err := NewStopWords("en")
+	require.NoError(t, err)
+
+	assert.False(t, s.Contains("dont"))
+	assert.True(t, s.Normalize(StripPunctuation).Contains("dont"))
+}
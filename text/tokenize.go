@@ -0,0 +1,180 @@
+package text
+
+import (
+	"bufio"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultTokenizer is the tokenizer used by new indexes.
+const DefaultTokenizer = "whitespace"
+
+var tokenizers = map[string]bufio.SplitFunc{
+	"whitespace": bufio.ScanWords,
+	"unicode":    ScanUnicodeWords,
+}
+
+// Tokenizer returns the split function registered under the given name.
+func Tokenizer(name string) (bufio.SplitFunc, error) {
+	split, ok := tokenizers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tokenizer %q", name)
+	}
+
+	return split, nil
+}
+
+// wordClass is the word break property of a rune, simplified from
+// Unicode Standard Annex #29.
+type wordClass int
+
+const (
+	classOther wordClass = iota
+	classLetter
+	classNumeric
+	classKatakana
+	classIdeographic
+	classExtendNumLet
+	classExtend
+	classMidLetter
+	classMidNum
+	classMidNumLet
+)
+
+// classOf returns the word class of r.
+func classOf(r rune) wordClass {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) || r == '\u200c' || r == '\u200d':
+		return classExtend
+	case unicode.In(r, unicode.Han, unicode.Hiragana):
+		return classIdeographic
+	case unicode.Is(unicode.Katakana, r) || r == '\u30fc' || r == '\uff70' || r == '\u309b' || r == '\u309c':
+		return classKatakana
+	case unicode.IsLetter(r):
+		return classLetter
+	case unicode.Is(unicode.Nd, r):
+		return classNumeric
+	case unicode.Is(unicode.Pc, r):
+		return classExtendNumLet
+	}
+
+	switch r {
+	case ':', '\u00b7', '\u0387', '\u05f4', '\u2027', '\ufe13', '\ufe55', '\uff1a':
+		return classMidLetter
+	case ',', ';', '\u037e', '\u0589', '\u060c', '\u060d', '\u066c', '\u07f8', '\u2044', '\ufe10', '\ufe14', '\ufe50', '\ufe54', '\uff0c', '\uff1b':
+		return classMidNum
+	case '.', '\'', '\u2018', '\u2019', '\u2024', '\ufe52', '\uff07', '\uff0e':
+		return classMidNumLet
+	}
+
+	return classOther
+}
+
+// startsWord returns true if a rune of class c can be the first rune of a word.
+func startsWord(c wordClass) bool {
+	switch c {
+	case classLetter, classNumeric, classKatakana, classIdeographic, classExtendNumLet:
+		return true
+	}
+
+	return false
+}
+
+// joins returns true if there is no word boundary between
+// a rune of class a followed by a rune of class b.
+func joins(a, b wordClass) bool {
+	switch a {
+	case classLetter, classNumeric:
+		return b == classLetter || b == classNumeric || b == classExtendNumLet
+	case classKatakana:
+		return b == classKatakana || b == classExtendNumLet
+	case classExtendNumLet:
+		return b == classLetter || b == classNumeric || b == classKatakana || b == classExtendNumLet
+	}
+
+	return false
+}
+
+// joinsAcross returns true if a rune of class mid between runes of
+// class a and b does not break the word, eg. "can't" or "3.14".
+func joinsAcross(a, mid, b wordClass) bool {
+	if a != b {
+		return false
+	}
+
+	switch a {
+	case classLetter:
+		return mid == classMidLetter || mid == classMidNumLet
+	case classNumeric:
+		return mid == classMidNum || mid == classMidNumLet
+	}
+
+	return false
+}
+
+// ScanUnicodeWords is a split function for a bufio.Scanner that returns
+// each word of text, using the word boundaries of Unicode Standard Annex #29.
+// Punctuation, symbols and whitespace between words are dropped and each
+// ideograph is returned as a word of its own.
+func ScanUnicodeWords(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// Skip leading runes that cannot start a word.
+	start, width := 0, 0
+	var prev wordClass
+	for ; start < len(data); start += width {
+		if !atEOF && !utf8.FullRune(data[start:]) {
+			return start, nil, nil
+		}
+
+		var r rune
+		r, width = utf8.DecodeRune(data[start:])
+		if prev = classOf(r); startsWord(prev) {
+			break
+		}
+	}
+
+	if start == len(data) {
+		return start, nil, nil
+	}
+
+	// Scan until the word breaks.
+	for i := start + width; i < len(data); i += width {
+		if !atEOF && !utf8.FullRune(data[i:]) {
+			return start, nil, nil
+		}
+
+		var r rune
+		r, width = utf8.DecodeRune(data[i:])
+		class := classOf(r)
+
+		switch {
+		case class == classExtend:
+			// combining marks never break a word
+		case prev == classIdeographic:
+			return i, data[start:i], nil
+		case joins(prev, class):
+			prev = class
+		case class == classMidLetter || class == classMidNum || class == classMidNumLet:
+			// the word only continues if the same kind of rune follows
+			j := i + width
+			if !atEOF && !utf8.FullRune(data[j:]) {
+				return start, nil, nil
+			}
+
+			next, _ := utf8.DecodeRune(data[j:])
+			if j == len(data) || !joinsAcross(prev, class, classOf(next)) {
+				return i, data[start:i], nil
+			}
+		default:
+			return i, data[start:i], nil
+		}
+	}
+
+	// If we're at EOF, we have a final, non-empty, non-terminated word. Return it.
+	if atEOF {
+		return len(data), data[start:], nil
+	}
+
+	// Request more data.
+	return start, nil, nil
+}
@@ -0,0 +1,68 @@
+package text
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanUnicodeWords(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input:    "Hello, World!",
+			expected: []string{"Hello", "World"},
+		},
+		{
+			input:    "can't stop -- won't stop",
+			expected: []string{"can't", "stop", "won't", "stop"},
+		},
+		{
+			input:    "pi is 3.14, e is 2,718.",
+			expected: []string{"pi", "is", "3.14", "e", "is", "2,718"},
+		},
+		{
+			input:    "snake_case and foo.bar.",
+			expected: []string{"snake_case", "and", "foo.bar"},
+		},
+		{
+			input:    "café naïve",
+			expected: []string{"café", "naïve"},
+		},
+		{
+			input:    "東京タワーへ行く",
+			expected: []string{"東", "京", "タワー", "へ", "行", "く"},
+		},
+		{
+			input:    "  ...  ",
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		// read a byte at a time to exercise partial runes and words
+		s := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(c.input)))
+		s.Split(ScanUnicodeWords)
+
+		var words []string
+		for s.Scan() {
+			words = append(words, s.Text())
+		}
+
+		assert.NoError(t, s.Err())
+		assert.Equal(t, c.expected, words, c.input)
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	_, err := Tokenizer("unicode")
+	assert.NoError(t, err)
+
+	_, err = Tokenizer("bogus")
+	assert.Error(t, err)
+}